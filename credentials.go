@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const accessKeyEnv = "S3_CLEANER_ACCESS_KEY"
+const secretKeyEnv = "S3_CLEANER_SECRET_KEY"
+
+// resolveCredentials returns the static access key and secret key to use,
+// plus a description of where they came from that is safe to print. Each
+// half is resolved on its own: the access key from --accesskey or
+// S3_CLEANER_ACCESS_KEY, the secret key from --secretkey, --secretkey-file
+// or S3_CLEANER_SECRET_KEY, in that order. When only one half is found
+// that way, the other one falls through to AWS_ACCESS_KEY_ID or
+// AWS_SECRET_ACCESS_KEY, so e.g. AWS_ACCESS_KEY_ID with --secretkey-file
+// works. Empty keys make the client use the rest of the AWS credential
+// chain (AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY with their session
+// token, the shared credentials file, a web identity token as used by EKS
+// IRSA, the EC2 role).
+func resolveCredentials() (accessKey, secretKey, source string, err error) {
+	if opts.SecretKey != "" && opts.SecretKeyFile != "" {
+		return "", "", "", fmt.Errorf("--secretkey and --secretkey-file are mutually exclusive")
+	}
+
+	var accessSource, secretSource string
+	switch {
+	case opts.AccessKey != "":
+		accessKey, accessSource = opts.AccessKey, "command line"
+	case os.Getenv(accessKeyEnv) != "":
+		accessKey, accessSource = os.Getenv(accessKeyEnv), "environment ("+accessKeyEnv+")"
+	}
+
+	switch {
+	case opts.SecretKey != "":
+		secretKey, secretSource = opts.SecretKey, "command line"
+	case opts.SecretKeyFile != "":
+		content, err := os.ReadFile(opts.SecretKeyFile)
+		if err != nil {
+			return "", "", "", fmt.Errorf("reading secret key file: %s", err)
+		}
+		secretKey, secretSource = strings.TrimRight(string(content), "\r\n"), opts.SecretKeyFile
+	case os.Getenv(secretKeyEnv) != "":
+		secretKey, secretSource = os.Getenv(secretKeyEnv), "environment ("+secretKeyEnv+")"
+	}
+
+	if accessKey == "" && secretKey == "" {
+		return "", "", "AWS credential chain", nil
+	}
+
+	if accessKey == "" {
+		accessKey, accessSource = os.Getenv("AWS_ACCESS_KEY_ID"), "environment (AWS_ACCESS_KEY_ID)"
+	}
+	if secretKey == "" {
+		secretKey, secretSource = os.Getenv("AWS_SECRET_ACCESS_KEY"), "environment (AWS_SECRET_ACCESS_KEY)"
+	}
+	if accessKey == "" {
+		return "", "", "", fmt.Errorf("a secret key was given from %s, but no access key (--accesskey, %s or AWS_ACCESS_KEY_ID)", secretSource, accessKeyEnv)
+	}
+	if secretKey == "" {
+		return "", "", "", fmt.Errorf("an access key was given from %s, but no secret key (--secretkey, --secretkey-file, %s or AWS_SECRET_ACCESS_KEY)", accessSource, secretKeyEnv)
+	}
+
+	if accessSource == secretSource {
+		return accessKey, secretKey, accessSource, nil
+	}
+	return accessKey, secretKey, "access key from " + accessSource + ", secret key from " + secretSource, nil
+}
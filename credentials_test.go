@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		accessKey     string
+		secretKey     string
+		secretKeyFile string
+		env           map[string]string
+		wantAccess    string
+		wantSecret    string
+		wantSource    string
+		wantErr       bool
+	}{
+		{name: "nothing", wantSource: "AWS credential chain"},
+		{name: "AWS environment only", env: map[string]string{"AWS_ACCESS_KEY_ID": "aws-key", "AWS_SECRET_ACCESS_KEY": "aws-secret"},
+			wantSource: "AWS credential chain"},
+		{name: "flags", accessKey: "key", secretKey: "secret",
+			wantAccess: "key", wantSecret: "secret", wantSource: "command line"},
+		{name: "tool environment", env: map[string]string{accessKeyEnv: "env-key", secretKeyEnv: "env-secret"},
+			wantAccess: "env-key", wantSecret: "env-secret",
+			wantSource: "access key from environment (" + accessKeyEnv + "), secret key from environment (" + secretKeyEnv + ")"},
+		{name: "AWS_ACCESS_KEY_ID with --secretkey-file", secretKeyFile: secretFile, env: map[string]string{"AWS_ACCESS_KEY_ID": "aws-key"},
+			wantAccess: "aws-key", wantSecret: "file-secret", wantSource: "access key from environment (AWS_ACCESS_KEY_ID), secret key from " + secretFile},
+		{name: "--accesskey with AWS_SECRET_ACCESS_KEY", accessKey: "key", env: map[string]string{"AWS_SECRET_ACCESS_KEY": "aws-secret"},
+			wantAccess: "key", wantSecret: "aws-secret", wantSource: "access key from command line, secret key from environment (AWS_SECRET_ACCESS_KEY)"},
+		{name: "--accesskey with the tool's secret key variable", accessKey: "key", env: map[string]string{secretKeyEnv: "env-secret"},
+			wantAccess: "key", wantSecret: "env-secret", wantSource: "access key from command line, secret key from environment (" + secretKeyEnv + ")"},
+		{name: "secret key file only", secretKeyFile: secretFile, wantErr: true},
+		{name: "access key only", accessKey: "key", wantErr: true},
+		{name: "--secretkey and --secretkey-file", accessKey: "key", secretKey: "secret", secretKeyFile: secretFile, wantErr: true},
+	}
+
+	saved := opts
+	defer func() { opts = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{accessKeyEnv, secretKeyEnv, "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+				t.Setenv(name, tt.env[name])
+			}
+			opts = saved
+			opts.AccessKey, opts.SecretKey, opts.SecretKeyFile = tt.accessKey, tt.secretKey, tt.secretKeyFile
+
+			accessKey, secretKey, source, err := resolveCredentials()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("no error, got keys %q %q from %s", accessKey, secretKey, source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accessKey != tt.wantAccess || secretKey != tt.wantSecret || source != tt.wantSource {
+				t.Errorf("got %q, %q from %q, want %q, %q from %q", accessKey, secretKey, source, tt.wantAccess, tt.wantSecret, tt.wantSource)
+			}
+		})
+	}
+}
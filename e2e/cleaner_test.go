@@ -0,0 +1,205 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stonezdj/s3-upload-cleaner/internal/e2etest"
+	"github.com/stonezdj/s3-upload-cleaner/pkg/cleaner"
+)
+
+// Multipart uploads can't be backdated, so the stale ones are started this
+// long before the fresh ones and the cleaner runs with this threshold.
+const threshold = 10 * time.Second
+
+func TestCleaner(t *testing.T) {
+	endpoint := os.Getenv("S3_CLEANER_E2E_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_CLEANER_E2E_ENDPOINT is not set, e.g. S3_CLEANER_E2E_ENDPOINT=localhost:9000")
+	}
+
+	ctx := context.Background()
+	accessKey := getenv("S3_CLEANER_E2E_ACCESS_KEY", "minioadmin")
+	secretKey := getenv("S3_CLEANER_E2E_SECRET_KEY", "minioadmin")
+
+	f, err := e2etest.NewFixture(ctx, endpoint, accessKey, secretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.CreateBucket(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.RemoveBucket()
+
+	want, err := seed(t, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := f.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadsBefore := f.Uploads()
+
+	newCleaner := func(dryRun bool) *cleaner.Cleaner {
+		output := io.Discard
+		if testing.Verbose() {
+			output = os.Stdout
+		}
+
+		c, err := cleaner.New(cleaner.Config{
+			Endpoint:         endpoint,
+			AccessKey:        accessKey,
+			SecretKey:        secretKey,
+			Buckets:          []string{f.Bucket},
+			CleanupThreshold: threshold,
+			Force:            true,
+			DryRun:           dryRun,
+			Output:           output,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	report, err := newCleaner(true).Run(ctx)
+	if err != nil {
+		t.Fatalf("dry run: %s", err)
+	}
+	if err := checkReport(report, want); err != nil {
+		t.Fatalf("dry run: %s", err)
+	}
+	if err := checkRemaining(f, before, uploadsBefore); err != nil {
+		t.Fatalf("dry run changed the bucket: %s", err)
+	}
+
+	report, err = newCleaner(false).Run(ctx)
+	if err != nil {
+		t.Fatalf("real run: %s", err)
+	}
+	if err := checkReport(report, want); err != nil {
+		t.Fatalf("real run: %s", err)
+	}
+	if err := checkRemaining(f, survivors(before, want.removedFolders), want.freshUploads); err != nil {
+		t.Fatalf("real run: %s", err)
+	}
+}
+
+// expectation is what a run over the seeded bucket should remove.
+type expectation struct {
+	removedFolders []string
+	staleUploads   []string
+	freshUploads   []string
+}
+
+// seed creates a few repositories with stale and fresh upload folders and
+// multipart uploads.
+func seed(t *testing.T, f *e2etest.Fixture) (expectation, error) {
+	var want expectation
+	repositories := []string{"library/nginx", "team/app", "team/tools"}
+
+	for _, repository := range repositories {
+		if err := f.Repository(repository); err != nil {
+			return want, err
+		}
+
+		folder, err := f.UploadFolder(repository, time.Now().Add(-48*time.Hour))
+		if err != nil {
+			return want, err
+		}
+		want.removedFolders = append(want.removedFolders, folder)
+	}
+
+	for _, repository := range repositories[:2] {
+		id, err := f.MultipartUpload(e2etest.RepositoriesPrefix + repository + "/_uploads/stale-mpu/data")
+		if err != nil {
+			return want, err
+		}
+		want.staleUploads = append(want.staleUploads, id)
+	}
+
+	t.Logf("waiting %s for the first uploads to become stale", threshold+2*time.Second)
+	time.Sleep(threshold + 2*time.Second)
+
+	for _, repository := range repositories[1:] {
+		if _, err := f.UploadFolder(repository, time.Now()); err != nil {
+			return want, err
+		}
+
+		id, err := f.MultipartUpload(e2etest.RepositoriesPrefix + repository + "/_uploads/fresh-mpu/data")
+		if err != nil {
+			return want, err
+		}
+		want.freshUploads = append(want.freshUploads, id)
+	}
+
+	return want, nil
+}
+
+func checkReport(report cleaner.Report, want expectation) error {
+	if report.ErrorCount > 0 {
+		return fmt.Errorf("%d errors: %s", report.ErrorCount, strings.Join(report.Errors, "; "))
+	}
+	if report.MPUsAborted != len(want.staleUploads) {
+		return fmt.Errorf("%d multipart uploads aborted, want %d", report.MPUsAborted, len(want.staleUploads))
+	}
+	if report.FoldersRemoved != len(want.removedFolders) {
+		return fmt.Errorf("%d upload folders removed, want %d", report.FoldersRemoved, len(want.removedFolders))
+	}
+	return nil
+}
+
+// checkRemaining compares the keys and multipart uploads left in the bucket
+// with the expected ones.
+func checkRemaining(f *e2etest.Fixture, keys, uploads []string) error {
+	got, err := f.Keys()
+	if err != nil {
+		return err
+	}
+	if err := compare("keys", got, keys); err != nil {
+		return err
+	}
+	sort.Strings(uploads)
+	return compare("multipart uploads", f.Uploads(), uploads)
+}
+
+// survivors returns the keys not below any of the removed folders.
+func survivors(keys, removedFolders []string) []string {
+	var kept []string
+	for _, key := range keys {
+		removed := false
+		for _, folder := range removedFolders {
+			if strings.HasPrefix(key, folder) {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+func compare(what string, got, want []string) error {
+	if strings.Join(got, "\n") == strings.Join(want, "\n") {
+		return nil
+	}
+	return fmt.Errorf("remaining %s:\n  %s\nwant:\n  %s", what, strings.Join(got, "\n  "), strings.Join(want, "\n  "))
+}
+
+func getenv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,12 @@
+// Package e2e holds the end-to-end test of the cleaner against a real S3
+// endpoint, usually a throwaway MinIO, which checks exactly which keys and
+// multipart uploads survive a dry run and a real run:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	S3_CLEANER_E2E_ENDPOINT=localhost:9000 go test -tags e2e ./e2e
+//
+// The credentials default to MinIO's minioadmin/minioadmin and can be set
+// with S3_CLEANER_E2E_ACCESS_KEY and S3_CLEANER_E2E_SECRET_KEY. Every run
+// uses a new bucket that is removed at the end. The test is skipped when
+// S3_CLEANER_E2E_ENDPOINT isn't set.
+package e2e
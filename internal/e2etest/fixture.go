@@ -0,0 +1,195 @@
+// Package e2etest seeds a bucket on a real S3 endpoint with a registry
+// layout for the end-to-end tests, and reads back what is left of it after
+// a run.
+package e2etest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const RepositoriesPrefix = "docker/registry/v2/repositories/"
+
+// Fixture is a bucket of its own on the endpoint.
+type Fixture struct {
+	Bucket string
+
+	ctx    context.Context
+	client *s3.Client
+	seq    int
+}
+
+// NewFixture returns a Fixture of a new bucket name on endpoint, a host:port
+// or a URL, with path-style addressing as MinIO needs. CreateBucket
+// creates it.
+func NewFixture(ctx context.Context, endpoint, accessKey, secretKey string) (*Fixture, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fixture{
+		Bucket: fmt.Sprintf("s3-cleaner-e2e-%d", time.Now().UnixNano()),
+		ctx:    ctx,
+		client: s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			o.UsePathStyle = true
+			o.BaseEndpoint = aws.String(EndpointURL(endpoint))
+		}),
+	}, nil
+}
+
+// EndpointURL returns endpoint as a URL, http:// unless it has a scheme.
+func EndpointURL(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return "http://" + endpoint
+}
+
+func (f *Fixture) CreateBucket() error {
+	_, err := f.client.CreateBucket(f.ctx, &s3.CreateBucketInput{Bucket: aws.String(f.Bucket)})
+	if err != nil {
+		return fmt.Errorf("creating bucket %s: %w", f.Bucket, err)
+	}
+	return nil
+}
+
+func (f *Fixture) Put(key, body string) error {
+	_, err := f.client.PutObject(f.ctx, &s3.PutObjectInput{
+		Bucket: aws.String(f.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("putting %s: %w", key, err)
+	}
+	return nil
+}
+
+// Repository seeds the layer and tag links of a pushed repository, so the
+// registry looks active.
+func (f *Fixture) Repository(name string) error {
+	base := RepositoriesPrefix + name + "/"
+	if err := f.Put(base+"_layers/sha256/0123abcd/link", "sha256:0123abcd"); err != nil {
+		return err
+	}
+	return f.Put(base+"_manifests/tags/latest/current/link", "sha256:4567ef01")
+}
+
+// UploadFolder seeds an _uploads folder of repository whose startedat file
+// says it was started at started, and returns the folder prefix.
+func (f *Fixture) UploadFolder(repository string, started time.Time) (string, error) {
+	f.seq++
+	folder := fmt.Sprintf("%s%s/_uploads/%08d-e2e-%04d/", RepositoriesPrefix, repository, f.seq, f.seq)
+
+	if err := f.Put(folder+"data", "layer data of upload "+folder); err != nil {
+		return "", err
+	}
+	if err := f.Put(folder+"hashstates/sha256/0", "hash state"); err != nil {
+		return "", err
+	}
+	return folder, f.Put(folder+"startedat", started.UTC().Format(time.RFC3339))
+}
+
+// MultipartUpload starts a multipart upload of key with one part uploaded
+// and returns its ID. Its age is the time since it was started, S3 can't
+// backdate it.
+func (f *Fixture) MultipartUpload(key string) (string, error) {
+	upload, err := f.client.CreateMultipartUpload(f.ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(f.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("starting multipart upload of %s: %w", key, err)
+	}
+
+	_, err = f.client.UploadPart(f.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(f.Bucket),
+		Key:        aws.String(key),
+		UploadId:   upload.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       bytes.NewReader([]byte("part 1")),
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploading part of %s: %w", key, err)
+	}
+
+	return *upload.UploadId, nil
+}
+
+// Keys returns every key in the bucket, sorted.
+func (f *Fixture) Keys() ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(f.client, &s3.ListObjectsV2Input{Bucket: aws.String(f.Bucket)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(f.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, *o.Key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Uploads returns the IDs of the multipart uploads in the bucket, sorted.
+func (f *Fixture) Uploads() []string {
+	var ids []string
+	for _, u := range f.listUploads() {
+		ids = append(ids, *u.UploadId)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *Fixture) listUploads() []types.MultipartUpload {
+	var uploads []types.MultipartUpload
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(f.Bucket)}
+	for {
+		page, err := f.client.ListMultipartUploads(f.ctx, input)
+		if err != nil {
+			return uploads
+		}
+		uploads = append(uploads, page.Uploads...)
+		if !aws.ToBool(page.IsTruncated) {
+			return uploads
+		}
+		input.KeyMarker, input.UploadIdMarker = page.NextKeyMarker, page.NextUploadIdMarker
+	}
+}
+
+// RemoveBucket aborts the remaining uploads and deletes the bucket with
+// everything in it.
+func (f *Fixture) RemoveBucket() {
+	for _, u := range f.listUploads() {
+		f.client.AbortMultipartUpload(f.ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(f.Bucket),
+			Key:      u.Key,
+			UploadId: u.UploadId,
+		})
+	}
+
+	keys, _ := f.Keys()
+	for _, key := range keys {
+		f.client.DeleteObject(f.ctx, &s3.DeleteObjectInput{Bucket: aws.String(f.Bucket), Key: aws.String(key)})
+	}
+
+	f.client.DeleteBucket(f.ctx, &s3.DeleteBucketInput{Bucket: aws.String(f.Bucket)})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyPositionalArgs(t *testing.T) {
+	saved := opts
+	defer func() { opts = saved }()
+
+	opts = saved
+	if err := applyPositionalArgs([]string{"https://s3.example.com", "registry", "AKIA", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Endpoint != "https://s3.example.com" || !slices.Equal(opts.Buckets, []string{"registry"}) ||
+		opts.AccessKey != "AKIA" || opts.SecretKey != "secret" {
+		t.Errorf("positional arguments set endpoint %q, buckets %v, keys %q %q",
+			opts.Endpoint, opts.Buckets, opts.AccessKey, opts.SecretKey)
+	}
+
+	opts = saved
+	opts.Buckets = []string{"other"}
+	if err := applyPositionalArgs([]string{"https://s3.example.com", "registry", "AKIA", "secret"}); err == nil {
+		t.Error("positional arguments combined with --bucket accepted")
+	}
+
+	opts = saved
+	if err := applyPositionalArgs([]string{"https://s3.example.com", "registry"}); err == nil {
+		t.Error("two positional arguments accepted")
+	}
+	if err := applyPositionalArgs(nil); err != nil {
+		t.Errorf("no arguments: %s", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stonezdj/s3-upload-cleaner/pkg/cleaner"
+)
+
+// Attempts made to deliver a notification when the receiver answers with
+// a server error.
+const notifyAttempts = 3
+
+// postWebhook POSTs the report as JSON to url. Failures are only printed,
+// a notification never changes the outcome of the cleanup.
+func postWebhook(url string, headers []string, report cleaner.Report) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		fmt.Printf(" ERROR: webhook: %s\n", err)
+		return
+	}
+
+	if err := postJSON(url, headers, body); err != nil {
+		fmt.Printf(" ERROR: webhook: %s\n", err)
+	}
+}
+
+// postJSON POSTs body to url, retrying when the receiver can't be reached
+// or answers with a server error. headers are "Name: value" pairs.
+func postJSON(url string, headers []string, body []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var lastErr error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 2 * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		for _, h := range headers {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+			}
+			req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("%s answered %s", url, resp.Status)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s answered %s", url, resp.Status)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", notifyAttempts, lastErr)
+}
+
+// slackMessage returns the Slack incoming webhook payload for the report.
+func slackMessage(r cleaner.Report) map[string]interface{} {
+	prefix, color := "", "good"
+	if r.DryRun {
+		prefix = "[dry run] "
+	}
+	if r.ErrorCount > 0 {
+		prefix = ":warning: " + prefix
+		color = "warning"
+	}
+
+	verb := []string{"aborted", "removed", "reclaimed"}
+	bytes := cleaner.FormatBytes(r.BytesReclaimed)
+	if r.DryRun {
+		verb = []string{"would abort", "would remove", "would reclaim"}
+		// The parts of multipart uploads are only added up with --estimate.
+		switch {
+		case r.UnsizedMPUs > 0 && r.BytesReclaimed == 0:
+			bytes = "unknown"
+		case r.UnsizedMPUs > 0:
+			bytes = "at least " + bytes
+		}
+	}
+
+	text := fmt.Sprintf("%ss3-upload-cleaner: bucket %s — %s %d MPUs, %s %d upload folders, %s %s, %d errors, took %s",
+		prefix, r.Bucket, verb[0], r.MPUsAborted, verb[1], r.FoldersRemoved, verb[2], bytes,
+		r.ErrorCount, r.Finished.Sub(r.Started).Round(time.Second))
+
+	attachment := map[string]interface{}{
+		"color":    color,
+		"fallback": text,
+		"text":     text,
+	}
+	if len(r.Errors) > 0 {
+		attachment["fields"] = []map[string]interface{}{{
+			"title": "Errors",
+			"value": strings.Join(r.Errors, "\n"),
+		}}
+	}
+
+	return map[string]interface{}{
+		"attachments": []interface{}{attachment},
+	}
+}
+
+// postSlack posts the report to a Slack incoming webhook. Failures are only
+// printed.
+func postSlack(url string, report cleaner.Report) {
+	body, err := json.Marshal(slackMessage(report))
+	if err != nil {
+		fmt.Printf(" ERROR: Slack notification: %s\n", err)
+		return
+	}
+
+	if err := postJSON(url, nil, body); err != nil {
+		fmt.Printf(" ERROR: Slack notification: %s\n", err)
+	}
+}
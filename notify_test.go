@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stonezdj/s3-upload-cleaner/pkg/cleaner"
+)
+
+func TestSlackMessageDryRunBytes(t *testing.T) {
+	tests := []struct {
+		report cleaner.Report
+		want   string
+	}{
+		{cleaner.Report{BytesReclaimed: 2048}, "reclaimed 2.0 KiB"},
+		{cleaner.Report{DryRun: true, BytesReclaimed: 2048}, "would reclaim 2.0 KiB"},
+		{cleaner.Report{DryRun: true, BytesReclaimed: 2048, UnsizedMPUs: 3}, "would reclaim at least 2.0 KiB"},
+		{cleaner.Report{DryRun: true, UnsizedMPUs: 3}, "would reclaim unknown"},
+	}
+
+	for _, tt := range tests {
+		attachment := slackMessage(tt.report)["attachments"].([]interface{})[0].(map[string]interface{})
+		if text := attachment["text"].(string); !strings.Contains(text, tt.want) {
+			t.Errorf("message %q, want it to contain %q", text, tt.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package cleaner
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// listedUpload is a multipart upload to abort read from the AbortList.
+type listedUpload struct {
+	line     int
+	key      string
+	uploadID string
+}
+
+// readAbortList reads the uploads of the AbortList, "-" for stdin. Every
+// line holds a key and an upload ID, separated by white space or a comma,
+// or is a JSON object with "key" and "upload_id" (or "uploadId"). Blank
+// lines, # comments and a CSV header are skipped. Malformed lines are
+// returned as errors with their line number.
+func readAbortList(path string) ([]listedUpload, []error, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var uploads []listedUpload
+	var malformed []error
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, uploadID, err := parseAbortListLine(line)
+		if err != nil {
+			malformed = append(malformed, fmt.Errorf("%w %d: %w, skipped", errMalformedAbortList, n, err))
+			continue
+		}
+		if len(uploads) == 0 && strings.EqualFold(key, "key") {
+			continue
+		}
+		uploads = append(uploads, listedUpload{line: n, key: key, uploadID: uploadID})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return uploads, malformed, nil
+}
+
+func parseAbortListLine(line string) (key, uploadID string, err error) {
+	var fields []string
+	switch {
+	case strings.HasPrefix(line, "{"):
+		var entry struct {
+			Key        string `json:"key"`
+			UploadID   string `json:"upload_id"`
+			UploadIDv2 string `json:"uploadId"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return "", "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		if entry.UploadID == "" {
+			entry.UploadID = entry.UploadIDv2
+		}
+		fields = []string{entry.Key, entry.UploadID}
+	case strings.Contains(line, ","):
+		fields, err = csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return "", "", fmt.Errorf("invalid CSV: %w", err)
+		}
+	default:
+		fields = strings.Fields(line)
+	}
+
+	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+		return "", "", errors.New(`expected "<key> <uploadId>"`)
+	}
+	return fields[0], fields[1], nil
+}
+
+// abortListed aborts the multipart uploads of the AbortList in the bucket
+// of the summary, without listing the bucket. The cleanup threshold only
+// applies with RespectAge; an upload that is already gone counts as done.
+func (cl *Cleaner) abortListed(ctx context.Context, summary *runSummary) error {
+	uploads, malformed, err := readAbortList(cl.cfg.AbortList)
+	if err != nil {
+		return &listingError{fmt.Errorf("reading abort list %s: %w", cl.cfg.AbortList, err)}
+	}
+	for _, err := range malformed {
+		cl.printf("ERROR: %s\n", err)
+		cl.addError(summary, err)
+	}
+
+	cl.printf("Aborting %d multipart uploads from %s:\n", len(uploads), cl.cfg.AbortList)
+	cl.progress.prefixesFound.Add(1)
+	defer cl.progress.prefixesDone.Add(1)
+
+	for _, u := range uploads {
+		if ctx.Err() != nil {
+			summary.stoppedAt = fmt.Sprintf("line %d of %s", u.line, cl.cfg.AbortList)
+			return nil
+		}
+		cl.abortListedUpload(ctx, summary, u)
+	}
+	return nil
+}
+
+func (cl *Cleaner) abortListedUpload(ctx context.Context, summary *runSummary, u listedUpload) {
+	c := candidate{
+		kind:     "mpu",
+		bucket:   summary.bucket,
+		key:      u.key,
+		uploadID: u.uploadID,
+		size:     -1,
+		action:   actionSkipped,
+	}
+	defer func() { cl.record(summary, c) }()
+
+	label := fmt.Sprintf("  Line %d: %s %s:", u.line, u.key, u.uploadID)
+
+	// The start time takes a listing of the key's uploads, which is only
+	// needed for the threshold. Dry runs check the upload exists instead.
+	if cl.cfg.RespectAge {
+		upload, err := cl.findUpload(ctx, summary.bucket, u.key, u.uploadID)
+		if err != nil {
+			cl.printf("%s ERROR: %s\n", label, err)
+			cl.addError(summary, err)
+			c.action = actionError
+			return
+		}
+		if upload == nil {
+			cl.printf("%s already gone\n", label)
+			summary.mpusGone++
+			return
+		}
+		c.started = *upload.Initiated
+		c.hours = int(time.Since(c.started).Hours())
+		if !cl.stale(c.started, summary.bucket, u.key) {
+			cl.printf("%s skipped, started %d hours ago%s\n", label, c.hours, cl.thresholdNote(summary.bucket, u.key))
+			return
+		}
+	} else if cl.cfg.DryRun {
+		_, err := cl.client.ListParts(ctx, &s3.ListPartsInput{
+			Bucket:   aws.String(summary.bucket),
+			Key:      aws.String(u.key),
+			UploadId: aws.String(u.uploadID),
+			MaxParts: aws.Int32(1),
+		})
+		if isNoSuchUpload(err) {
+			cl.printf("%s already gone\n", label)
+			summary.mpusGone++
+			return
+		}
+		if err != nil {
+			cl.printf("%s ERROR: %s\n", label, err)
+			cl.addError(summary, err)
+			c.action = actionError
+			return
+		}
+	}
+
+	if cl.cfg.DryRun {
+		cl.printf("%s would be aborted\n", label)
+		summary.mpusRemoved++
+		c.action = actionWouldRemove
+		return
+	}
+
+	entry := auditEntry{
+		Time:     time.Now().UTC(),
+		Action:   "abort_mpu",
+		Bucket:   summary.bucket,
+		Key:      u.key,
+		UploadID: u.uploadID,
+	}
+	if !cl.auditReady() {
+		return
+	}
+	_, err := cl.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(summary.bucket),
+		Key:      aws.String(u.key),
+		UploadId: aws.String(u.uploadID),
+	})
+	cl.audit(auditOutcome(entry, err))
+
+	switch {
+	case isNoSuchUpload(err):
+		cl.printf("%s already gone\n", label)
+		summary.mpusGone++
+	case err != nil:
+		cl.printf("%s ERROR: %s\n", label, err)
+		cl.addError(summary, err)
+		c.action = actionError
+	default:
+		cl.printf("%s aborted\n", label)
+		summary.mpusRemoved++
+		c.action = actionRemoved
+	}
+}
+
+// findUpload returns the multipart upload uploadID of key, nil when there
+// is none.
+func (cl *Cleaner) findUpload(ctx context.Context, bucket, key, uploadID string) (*types.MultipartUpload, error) {
+	uploads, err := cl.listMultipartUploads(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("listing multipart uploads of %s: %w", key, err)
+	}
+	for i, upload := range uploads {
+		if aws.ToString(upload.Key) == key && aws.ToString(upload.UploadId) == uploadID {
+			return &uploads[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// isNoSuchUpload reports whether err means the multipart upload doesn't
+// exist (any more).
+func isNoSuchUpload(err error) bool {
+	var noSuchUpload *types.NoSuchUpload
+	var apiErr smithy.APIError
+	return errors.As(err, &noSuchUpload) || errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchUpload"
+}
@@ -0,0 +1,125 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAbortListLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		key      string
+		uploadID string
+		wantErr  bool
+	}{
+		{"space", "repo/_uploads/a/data id1", "repo/_uploads/a/data", "id1", false},
+		{"tabs and spaces", "repo/_uploads/a/data \t  id1", "repo/_uploads/a/data", "id1", false},
+		{"CSV", "repo/_uploads/a/data,id1", "repo/_uploads/a/data", "id1", false},
+		{"quoted CSV", `"repo/_uploads/a b/data","id1"`, "repo/_uploads/a b/data", "id1", false},
+		{"JSON", `{"key": "repo/_uploads/a/data", "upload_id": "id1"}`, "repo/_uploads/a/data", "id1", false},
+		{"JSON uploadId", `{"key": "repo/_uploads/a/data", "uploadId": "id1"}`, "repo/_uploads/a/data", "id1", false},
+		{"key only", "repo/_uploads/a/data", "", "", true},
+		{"three fields", "repo/_uploads/a/data id1 id2", "", "", true},
+		{"empty CSV field", "repo/_uploads/a/data,", "", "", true},
+		{"unterminated CSV quote", `"repo/_uploads/a/data,id1`, "", "", true},
+		{"invalid JSON", `{"key": "repo/_uploads/a/data"`, "", "", true},
+		{"JSON without upload ID", `{"key": "repo/_uploads/a/data"}`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, uploadID, err := parseAbortListLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAbortListLine(%q) error %v, want error %v", tt.line, err, tt.wantErr)
+			}
+			if key != tt.key || uploadID != tt.uploadID {
+				t.Errorf("parseAbortListLine(%q) = %q, %q, want %q, %q", tt.line, key, uploadID, tt.key, tt.uploadID)
+			}
+		})
+	}
+}
+
+// writeAbortList writes lines to an abort list file and returns its path.
+func writeAbortList(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "uploads.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadAbortList(t *testing.T) {
+	path := writeAbortList(t,
+		"key,upload_id",
+		"# leaked uploads",
+		"",
+		"a/data id1",
+		"a/data",
+		"   ",
+		"b/data,id2",
+		`{"key": "c/data", "upload_id": "id3"}`,
+		"{",
+	)
+
+	uploads, malformed, err := readAbortList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []listedUpload{{4, "a/data", "id1"}, {7, "b/data", "id2"}, {8, "c/data", "id3"}}
+	if !slices.Equal(uploads, want) {
+		t.Errorf("uploads %+v, want %+v", uploads, want)
+	}
+	if len(malformed) != 2 || !strings.Contains(malformed[0].Error(), " 5: ") || !strings.Contains(malformed[1].Error(), " 9: ") {
+		t.Errorf("malformed lines %v, want lines 5 and 9", malformed)
+	}
+}
+
+func TestAbortListed(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	present, gone := testRepositories+"repo/_uploads/a/data", testRepositories+"repo/_uploads/b/data"
+
+	for _, tt := range []struct {
+		name   string
+		dryRun bool
+	}{{"abort", false}, {"dry run", true}} {
+		dryRun := tt.dryRun
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			f.putMultipartUpload(present, "id1", old)
+			path := writeAbortList(t, present+" id1", gone+" id2", "malformed")
+
+			cl, out := newTestCleaner(t, f, Config{AbortList: path, DryRun: dryRun})
+			report := run(t, cl, out)
+
+			if report.MPUsAborted != 1 || report.ErrorCount != 1 {
+				t.Errorf("%d uploads aborted and %d errors, want 1 and the malformed line\n%s", report.MPUsAborted, report.ErrorCount, out)
+			}
+			for _, want := range []string{"MPUs already gone: 1", "line 3"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output without %q\n%s", want, out)
+				}
+			}
+
+			aborts := f.callsOf("AbortMultipartUpload")
+			if dryRun {
+				// The uploads are probed, the present one is left alone.
+				if len(aborts) != 0 || len(f.callsOf("ListParts")) != 2 || len(f.uploadIDs()) != 1 {
+					t.Errorf("dry run made %d aborts and %d ListParts calls, left %v", len(aborts), len(f.callsOf("ListParts")), f.uploadIDs())
+				}
+				if !strings.Contains(out.String(), "would be aborted") {
+					t.Errorf("dry run output without the upload that would be aborted\n%s", out)
+				}
+				return
+			}
+			if len(aborts) != 2 || len(f.uploadIDs()) != 0 || len(f.callsOf("ListParts")) != 0 {
+				t.Errorf("made %v, left %v, want both listed uploads aborted without probing", aborts, f.uploadIDs())
+			}
+		})
+	}
+}
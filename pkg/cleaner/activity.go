@@ -0,0 +1,128 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Number of repository prefixes sampled, and keys listed per sample, when
+// checking whether the registry is still in use. Keeps the check to a
+// handful of bounded listings regardless of bucket size.
+const activitySamplePrefixes = 5
+const activitySampleKeys = 1000
+
+type registryActivity struct {
+	prefix  string
+	objects int
+	tagKeys int
+	newest  time.Time
+
+	// seen holds the keys sampled, the listing of a repository and of its
+	// tags can both return a tag link.
+	seen map[string]bool
+}
+
+// checkRegistryActivity samples a few of the commonPrefixes listed below
+// prefix and prints a warning when nothing in the sample was modified recently,
+// which usually means the bucket is a stale copy rather than the live
+// registry.
+func (cl *Cleaner) checkRegistryActivity(ctx context.Context, bucket, prefix string, commonPrefixes []types.CommonPrefix) registryActivity {
+	activity := registryActivity{prefix: prefix, seen: map[string]bool{}}
+
+	for i, cp := range commonPrefixes {
+		if i >= activitySamplePrefixes {
+			break
+		}
+
+		repositories := cl.sample(ctx, &activity, bucket, *cp.Prefix)
+
+		// Tag links are rewritten on every push, so they are the best
+		// indicator of activity even when listing the repository itself
+		// only reached its layer links.
+		sampled := 0
+		for repository := range repositories {
+			if sampled >= activitySamplePrefixes {
+				break
+			}
+			cl.sample(ctx, &activity, bucket, repository+"_manifests/tags/")
+			sampled++
+		}
+	}
+
+	if activity.inactive(cl.cfg.InactiveDays) {
+		cl.println("**********************************************************************")
+		cl.println("WARNING: this looks like an inactive/backup registry.")
+		cl.printf("WARNING: %s\n", activity.describe(cl.cfg.InactiveDays))
+		cl.println("**********************************************************************")
+		cl.println()
+	}
+
+	return activity
+}
+
+// sample lists a single bounded page below prefix, records the objects in
+// it in a and returns the repository paths (ending in "/") found in the listing.
+func (cl *Cleaner) sample(ctx context.Context, a *registryActivity, bucket, prefix string) map[string]bool {
+	repositories := map[string]bool{}
+
+	objs, err := cl.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(activitySampleKeys),
+	})
+
+	if err != nil {
+		cl.printf(" ERROR: %s\n", err)
+		return repositories
+	}
+
+	for _, o := range objs.Contents {
+		if a.seen[*o.Key] {
+			continue
+		}
+		a.seen[*o.Key] = true
+
+		a.objects++
+		if o.LastModified != nil && o.LastModified.After(a.newest) {
+			a.newest = *o.LastModified
+		}
+
+		if strings.Contains(*o.Key, "/_manifests/tags/") {
+			a.tagKeys++
+		}
+
+		for _, marker := range []string{"/_layers/", "/_manifests/", "/_uploads/"} {
+			if i := strings.Index(*o.Key, marker); i >= 0 {
+				repositories[(*o.Key)[:i+1]] = true
+				break
+			}
+		}
+	}
+
+	return repositories
+}
+
+func (a registryActivity) inactive(days int) bool {
+	return a.objects > 0 && time.Since(a.newest) > time.Duration(days)*24*time.Hour
+}
+
+func (a registryActivity) describe(days int) string {
+	if a.objects == 0 {
+		return fmt.Sprintf("no objects found under %s", a.prefix)
+	}
+
+	age := int(time.Since(a.newest).Hours() / 24)
+	state := "active"
+	if a.inactive(days) {
+		state = fmt.Sprintf("inactive, nothing modified in the last %d days", days)
+	}
+
+	return fmt.Sprintf("%s (newest of %d sampled objects, %d tag links, modified %s, %d days ago)",
+		state, a.objects, a.tagKeys, a.newest.Format(time.RFC3339), age)
+}
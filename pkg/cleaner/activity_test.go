@@ -0,0 +1,88 @@
+package cleaner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestCheckRegistryActivity(t *testing.T) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	tests := []struct {
+		name     string
+		layout   func(f *fakeS3)
+		inactive bool
+		objects  int
+		describe string
+	}{
+		{
+			name: "active",
+			layout: func(f *fakeS3) {
+				f.put(testRepositories+"repo/_layers/sha256/x/link", []byte("sha256:x"), old)
+				f.put(testRepositories+"repo/_manifests/tags/latest/current/link", []byte("sha256:y"), time.Now())
+			},
+			objects:  2,
+			describe: "active (newest of 2 sampled objects, 1 tag links",
+		},
+		{
+			name: "inactive",
+			layout: func(f *fakeS3) {
+				f.put(testRepositories+"repo/_layers/sha256/x/link", []byte("sha256:x"), old)
+				f.put(testRepositories+"repo/_manifests/tags/latest/current/link", []byte("sha256:y"), old)
+			},
+			inactive: true,
+			objects:  2,
+			describe: "inactive, nothing modified in the last 30 days",
+		},
+		{
+			name:     "empty",
+			layout:   func(f *fakeS3) {},
+			describe: "no objects found under " + testRepositories,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			tt.layout(f)
+			cl, out := newTestCleaner(t, f, Config{})
+
+			prefixes := []types.CommonPrefix{{Prefix: aws.String(testRepositories + "repo/")}}
+			activity := cl.checkRegistryActivity(context.Background(), "bucket", testRepositories, prefixes)
+
+			if got := activity.inactive(cl.cfg.InactiveDays); got != tt.inactive {
+				t.Errorf("inactive = %t, want %t", got, tt.inactive)
+			}
+			if activity.objects != tt.objects {
+				t.Errorf("%d objects sampled, want %d", activity.objects, tt.objects)
+			}
+			if got := activity.describe(cl.cfg.InactiveDays); !strings.HasPrefix(got, tt.describe) {
+				t.Errorf("describe = %q, want it to start with %q", got, tt.describe)
+			}
+			if warned := strings.Contains(out.String(), "inactive/backup registry"); warned != tt.inactive {
+				t.Errorf("warning printed: %t, want %t\n%s", warned, tt.inactive, out)
+			}
+		})
+	}
+}
+
+func TestRunRefusesInactiveRegistry(t *testing.T) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/u/", old, 1)
+
+	cl, out := newTestCleaner(t, f, Config{})
+	cl.cfg.AllowInactive = false
+	report, _ := cl.Run(context.Background())
+
+	if report.FoldersRemoved != 0 || len(f.keys()) != 2 {
+		t.Errorf("removed %d folders from an inactive registry, keys left %v\n%s", report.FoldersRemoved, f.keys(), out)
+	}
+	if !strings.Contains(out.String(), "refusing to remove uploads from an inactive registry") {
+		t.Errorf("refusal not reported\n%s", out)
+	}
+}
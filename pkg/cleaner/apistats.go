@@ -0,0 +1,137 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// APICallStats counts the requests of one S3 operation. Calls are the
+// requests made by the cleanup, Retries the extra attempts the SDK made
+// for them and Errors the calls that failed after those.
+type APICallStats struct {
+	Calls   int64 `json:"calls"`
+	Retries int64 `json:"retries"`
+	Errors  int64 `json:"errors"`
+}
+
+// apiStats counts the requests of the run by operation, for sizing request
+// budgets and rate limits.
+type apiStats struct {
+	mu  sync.Mutex
+	ops map[string]*APICallStats
+}
+
+func (s *apiStats) op(name string) *APICallStats {
+	if s.ops == nil {
+		s.ops = map[string]*APICallStats{}
+	}
+	op, ok := s.ops[name]
+	if !ok {
+		op = &APICallStats{}
+		s.ops[name] = op
+	}
+	return op
+}
+
+func (s *apiStats) call(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	op := s.op(name)
+	op.Calls++
+	if err != nil {
+		op.Errors++
+	}
+}
+
+func (s *apiStats) retried(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.op(name).Retries++
+}
+
+// snapshot returns a copy of the counters by operation name.
+func (s *apiStats) snapshot() map[string]APICallStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := make(map[string]APICallStats, len(s.ops))
+	for name, op := range s.ops {
+		ops[name] = *op
+	}
+	return ops
+}
+
+// attemptsKey is the stack value counting the attempts of one call.
+type attemptsKey struct{}
+
+// countRetries adds the middleware counting the retries of the SDK to a
+// client's stack. Every attempt passes the step after "Retry", all but
+// the first of a call are retries.
+func (s *apiStats) countRetries(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountAttempts", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		return next.HandleInitialize(middleware.WithStackValue(ctx, attemptsKey{}, new(int)), in)
+	}), middleware.Before)
+	if err != nil {
+		return err
+	}
+
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("CountRetries", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler,
+	) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		if attempts, ok := middleware.GetStackValue(ctx, attemptsKey{}).(*int); ok {
+			*attempts++
+			if *attempts > 1 {
+				s.retried(awsmiddleware.GetOperationName(ctx))
+			}
+		}
+		return next.HandleFinalize(ctx, in)
+	}), "Retry", middleware.After)
+}
+
+// printAPICalls prints the requests of the run by operation, with the
+// wall-clock time of the run and the average request rate.
+func (cl *Cleaner) printAPICalls(elapsed time.Duration) {
+	ops := cl.apiStats.snapshot()
+
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total APICallStats
+	for _, op := range ops {
+		total.Calls += op.Calls
+		total.Retries += op.Retries
+		total.Errors += op.Errors
+	}
+
+	cl.println()
+	cl.println("API calls:")
+
+	w := tabwriter.NewWriter(cl.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  Operation\tCalls\tRetries\tErrors")
+	for _, name := range names {
+		op := ops[name]
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\n", name, op.Calls, op.Retries, op.Errors)
+	}
+	fmt.Fprintf(w, "  Total\t%d\t%d\t%d\n", total.Calls, total.Retries, total.Errors)
+	w.Flush()
+
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(total.Calls) / elapsed.Seconds()
+	}
+	cl.printf("  Run time: %s, %.1f calls/s\n", elapsed.Round(time.Second), rate)
+}
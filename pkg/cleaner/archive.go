@@ -0,0 +1,92 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CopyObject can't copy objects larger than 5 GiB in a single request.
+const maxCopySize = 5 << 30
+
+// archiveObjects copies the current version of objects to the archive
+// location given with --archive-prefix and checks every copy arrived with
+// the same size. Any failure is returned so the caller keeps the originals.
+func (cl *Cleaner) archiveObjects(ctx context.Context, summary *runSummary, objects []objectVersion) error {
+	if cl.cfg.ArchivePrefix == "" {
+		return nil
+	}
+
+	archiveBucket := cl.cfg.ArchiveBucket
+	if archiveBucket == "" {
+		archiveBucket = summary.bucket
+	}
+
+	for _, o := range objects {
+		// Placeholders hold nothing worth keeping.
+		if !o.current || o.placeholder() {
+			continue
+		}
+
+		key := cl.archiveKey(o.key)
+
+		if o.size > maxCopySize {
+			return fmt.Errorf("archiving %s: %d bytes is more than CopyObject supports, keeping the folder", o.key, o.size)
+		}
+
+		_, err := cl.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(archiveBucket),
+			Key:        aws.String(key),
+			CopySource: aws.String(copySource(summary.bucket, o)),
+		}, cl.copyOptions()...)
+
+		if err != nil {
+			return fmt.Errorf("archiving %s to %s/%s: %w", o.key, archiveBucket, key, err)
+		}
+
+		head, err := cl.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(archiveBucket),
+			Key:    aws.String(key),
+		})
+
+		if err != nil {
+			return fmt.Errorf("verifying archive copy %s/%s: %w", archiveBucket, key, err)
+		}
+
+		if aws.ToInt64(head.ContentLength) != o.size {
+			return fmt.Errorf("verifying archive copy %s/%s: %d bytes, expected %d", archiveBucket, key, aws.ToInt64(head.ContentLength), o.size)
+		}
+
+		cl.printf("    Archived %s to %s/%s\n", o.key, archiveBucket, key)
+	}
+
+	return nil
+}
+
+// archiveKey returns the key an object is archived to.
+func (cl *Cleaner) archiveKey(key string) string {
+	prefix := strings.TrimSuffix(cl.cfg.ArchivePrefix, "/") + "/"
+	if cl.cfg.ArchiveDated {
+		prefix += time.Now().UTC().Format("2006-01-02") + "/"
+	}
+	return prefix + key
+}
+
+// copySource returns the URL encoded CopySource of o in bucket.
+func copySource(bucket string, o objectVersion) string {
+	segments := strings.Split(bucket+"/"+o.key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	source := strings.Join(segments, "/")
+	if o.versionID != "" {
+		source += "?versionId=" + url.QueryEscape(o.versionID)
+	}
+	return source
+}
@@ -0,0 +1,55 @@
+package cleaner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCopyTimeout(t *testing.T) {
+	// Every request takes longer than the RequestTimeout.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			io.WriteString(w, `<CopyObjectResult><ETag>"x"</ETag></CopyObjectResult>`)
+		}
+	}))
+	defer server.Close()
+
+	cl, err := New(Config{
+		Endpoint:         server.URL,
+		AccessKey:        "key",
+		SecretKey:        "secret",
+		Buckets:          []string{"bucket"},
+		CleanupThreshold: 12 * time.Hour,
+		RequestTimeout:   100 * time.Millisecond,
+		CopyTimeout:      5 * time.Second,
+		NoProxy:          "*",
+		Output:           io.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	_, err = cl.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key")},
+		func(o *s3.Options) { o.RetryMaxAttempts = 1 })
+	if err == nil {
+		t.Error("HeadObject didn't time out after the RequestTimeout")
+	}
+
+	_, err = cl.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String("bucket"),
+		Key:        aws.String("archive/key"),
+		CopySource: aws.String("bucket/key"),
+	}, append(cl.copyOptions(), func(o *s3.Options) { o.RetryMaxAttempts = 1 })...)
+	if err != nil {
+		t.Errorf("CopyObject failed within the CopyTimeout: %s", err)
+	}
+}
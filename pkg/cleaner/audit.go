@@ -0,0 +1,125 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// errAuditLog is wrapped by the error stopping the run when the audit log
+// can't be written, no further removal would be recorded.
+var errAuditLog = errors.New("writing audit log")
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	UploadID  string    `json:"upload_id,omitempty"`
+	VersionID string    `json:"version_id,omitempty"`
+	Size      *int64    `json:"size,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends a JSON line per destructive request to --audit-log. A
+// nil *auditLog records nothing.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+// record appends the entries of one request and syncs the file, so the
+// entries are on disk before the next request is made.
+func (a *auditLog) record(entries ...auditEntry) error {
+	if a == nil || len(entries) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var buf []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errAuditLog, err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	if _, err := a.file.Write(buf); err != nil {
+		return fmt.Errorf("%w: %w", errAuditLog, err)
+	}
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", errAuditLog, err)
+	}
+	return nil
+}
+
+// sync flushes the entries written so far to disk.
+func (a *auditLog) sync() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.file.Sync(); err != nil {
+		return fmt.Errorf("%w: %w", errAuditLog, err)
+	}
+	return nil
+}
+
+func (a *auditLog) close(out io.Writer) {
+	if a == nil {
+		return
+	}
+	if err := a.file.Close(); err != nil {
+		fmt.Fprintf(out, " ERROR: closing audit log: %s\n", err)
+	}
+}
+
+// audit records entries in the audit log. When that fails the run is
+// stopped, nothing may be removed without being recorded.
+func (cl *Cleaner) audit(entries ...auditEntry) {
+	if err := cl.auditLog.record(entries...); err != nil {
+		cl.printf(" ERROR: %s, stopping the run\n", err)
+		cl.halt(err)
+	}
+}
+
+// auditReady syncs the audit log before a request is made and reports
+// whether the request may be made, it can't when the log can't be written
+// and the outcome wouldn't be recorded.
+func (cl *Cleaner) auditReady() bool {
+	if err := cl.auditLog.sync(); err != nil {
+		cl.printf(" ERROR: %s, stopping the run\n", err)
+		cl.halt(err)
+		return false
+	}
+	return true
+}
+
+// auditOutcome returns the outcome fields of an audit entry for err.
+func auditOutcome(e auditEntry, err error) auditEntry {
+	e.Outcome = "success"
+	if err != nil {
+		e.Outcome, e.Error = "error", err.Error()
+	}
+	return e
+}
@@ -0,0 +1,86 @@
+package cleaner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("audit log line %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/a/", old, 100, 200)
+	f.putMultipartUpload(testRepositories+"repo/_uploads/b/data", "mpu", old)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cl, out := newTestCleaner(t, f, Config{AuditLog: path})
+	run(t, cl, out)
+
+	// One line per action: the three objects of the folder and the
+	// multipart upload.
+	entries := readAuditLog(t, path)
+	actions := map[string]int{}
+	for _, e := range entries {
+		if e.Outcome != "success" {
+			t.Errorf("audit log entry %+v, want successes only", e)
+		}
+		actions[e.Action+" "+e.Key+" "+e.UploadID]++
+	}
+	if len(entries) != 4 || len(actions) != 4 {
+		t.Errorf("%d audit log entries of %d actions, want 4 of 4\n%s", len(entries), len(actions), out)
+	}
+	if n := actions["abort_mpu "+testRepositories+"repo/_uploads/b/data mpu"]; n != 1 {
+		t.Errorf("%d entries of the aborted upload, want 1", n)
+	}
+}
+
+func TestAuditLogFailure(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.putMultipartUpload(testRepositories+"repo/_uploads/a/data", "mpu", old)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cl, _ := newTestCleaner(t, f, Config{})
+	var err error
+	if cl.auditLog, err = openAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	// Writes to the closed file fail.
+	cl.auditLog.file.Close()
+
+	ctx, halt := context.WithCancelCause(context.Background())
+	cl.halt = halt
+	summary := &runSummary{bucket: "bucket"}
+	removed, _ := cl.cleanMPUs(ctx, summary, testRepositories)
+
+	if removed != 0 || len(f.callsOf("AbortMultipartUpload")) != 0 {
+		t.Errorf("%d uploads aborted that the audit log could not record", removed)
+	}
+	if context.Cause(ctx) == nil {
+		t.Error("run not stopped when the audit log can't be written")
+	}
+}
@@ -0,0 +1,182 @@
+package cleaner
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions recorded for a candidate.
+const (
+	actionWouldRemove = "would-remove"
+	actionRemoved     = "removed"
+	actionPartial     = "partial"
+	actionSkipped     = "skipped"
+	actionError       = "error"
+)
+
+// candidate is a multipart upload or upload folder considered for removal.
+// size is -1 when it isn't known without extra requests.
+type candidate struct {
+	kind     string
+	bucket   string
+	key      string
+	uploadID string
+	started  time.Time
+	hours    int
+	size     int64
+	action   string
+}
+
+// repository returns the repository name of the candidate, the path between
+// repositories/ and the registry's _uploads, _layers or _manifests folders.
+func (c candidate) repository() string {
+	return repositoryName(c.key)
+}
+
+func repositoryName(key string) string {
+	i := strings.Index(key, repositoriesPrefix)
+	if i < 0 {
+		return ""
+	}
+
+	name := key[i+len(repositoriesPrefix):]
+	for _, dir := range []string{"/_uploads/", "/_layers/", "/_manifests/"} {
+		if j := strings.Index(name, dir); j >= 0 {
+			return name[:j]
+		}
+	}
+	return strings.TrimSuffix(name, "/")
+}
+
+// csvReport writes candidates to the --report-csv file. Every row is
+// flushed right away so a killed run still leaves a usable partial report.
+// A nil *csvReport discards everything.
+type csvReport struct {
+	file *os.File
+	w    *csv.Writer
+	out  io.Writer
+}
+
+var csvHeader = []string{"type", "bucket", "repository", "key", "uploadId", "started", "age_hours", "size_bytes", "action"}
+
+func openCSVReport(path string, out io.Writer) (*csvReport, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &csvReport{file: file, w: csv.NewWriter(file), out: out}
+	if err := r.write(csvHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *csvReport) add(c candidate) {
+	if r == nil {
+		return
+	}
+
+	started, hours, size := "", "", ""
+	if !c.started.IsZero() {
+		started = c.started.UTC().Format(time.RFC3339)
+		hours = strconv.Itoa(c.hours)
+	}
+	if c.size >= 0 {
+		size = strconv.FormatInt(c.size, 10)
+	}
+
+	err := r.write([]string{c.kind, c.bucket, c.repository(), c.key, c.uploadID, started, hours, size, c.action})
+	if err != nil {
+		fmt.Fprintf(r.out, " ERROR: writing CSV report: %s\n", err)
+	}
+}
+
+func (r *csvReport) write(row []string) error {
+	if err := r.w.Write(row); err != nil {
+		return err
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+func (r *csvReport) close() {
+	if r == nil {
+		return
+	}
+	if err := r.file.Close(); err != nil {
+		fmt.Fprintf(r.out, " ERROR: closing CSV report: %s\n", err)
+	}
+}
+
+// repositoryTotal adds up the candidates removed from one repository.
+type repositoryTotal struct {
+	name    string
+	mpus    int
+	folders int
+	bytes   int64
+}
+
+// repositoryTotals groups removed candidates by repository, the ones that
+// reclaimed the most first. Multipart uploads have no known size, so ties
+// are broken by the number of candidates.
+func repositoryTotals(candidates []candidate) []repositoryTotal {
+	byName := map[string]*repositoryTotal{}
+	for _, c := range candidates {
+		total := byName[c.repository()]
+		if total == nil {
+			total = &repositoryTotal{name: c.repository()}
+			byName[c.repository()] = total
+		}
+
+		if c.kind == "mpu" {
+			total.mpus++
+		} else {
+			total.folders++
+		}
+		if c.size > 0 {
+			total.bytes += c.size
+		}
+	}
+
+	totals := make([]repositoryTotal, 0, len(byName))
+	for _, total := range byName {
+		totals = append(totals, *total)
+	}
+
+	sort.Slice(totals, func(i, j int) bool {
+		a, b := totals[i], totals[j]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		if a.mpus+a.folders != b.mpus+b.folders {
+			return a.mpus+a.folders > b.mpus+b.folders
+		}
+		return a.name < b.name
+	})
+
+	return totals
+}
+
+// topRepositories returns the repository totals over all summaries, limited
+// to --top rows.
+func (cl *Cleaner) topRepositories(summaries []*runSummary) []repositoryTotal {
+	var removed []candidate
+	for _, r := range summaries {
+		removed = append(removed, r.removed...)
+	}
+
+	totals := repositoryTotals(removed)
+	if cl.cfg.Top > 0 && len(totals) > cl.cfg.Top {
+		totals = totals[:cl.cfg.Top]
+	}
+	return totals
+}
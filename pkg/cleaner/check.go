@@ -0,0 +1,149 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Key and upload ID of the abort and delete probes. Neither exists, the
+// probes only find out whether the request is permitted.
+const (
+	probeObject   = ".s3-upload-cleaner-check"
+	probeUploadID = "s3-upload-cleaner-check"
+)
+
+// Check verifies, without removing anything, that every bucket and root
+// directory can be cleaned: the bucket is reachable, the repositories are
+// where the registry layout puts them, and listing, aborting and deleting
+// are permitted. It prints a line per check and returns the number of
+// checks that failed.
+func (cl *Cleaner) Check(ctx context.Context) (failed int) {
+	cl.printf("Endpoint: %s\n", cl.endpoint)
+	cl.printf("Credentials: %s\n", cl.cfg.CredentialSource)
+
+	result := func(name string, err error) {
+		if err != nil {
+			failed++
+			cl.printf("  FAIL  %s: %s\n", name, err)
+			return
+		}
+		cl.printf("  PASS  %s\n", name)
+	}
+
+	for _, bucket := range cl.buckets {
+		cl.println()
+		cl.printf("Bucket %s:\n", bucket)
+
+		_, err := cl.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		result("bucket is reachable", err)
+		if err != nil {
+			continue
+		}
+		versioned := cl.bucketVersioned(ctx, bucket)
+
+		for _, rootDir := range cl.rootDirs {
+			prefix := repositoriesPath(rootDir)
+			if cl.cfg.Prefix != nil {
+				prefix = *cl.cfg.Prefix
+			} else {
+				result("repositories found below "+prefix, cl.checkRepositories(ctx, bucket, prefix))
+			}
+
+			_, err := cl.client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{
+				Bucket:     aws.String(bucket),
+				Prefix:     aws.String(prefix),
+				MaxUploads: aws.Int32(1),
+			})
+			result("listing multipart uploads below "+prefix+" is permitted", err)
+
+			probe := prefix + probeObject
+			result("aborting multipart uploads below "+prefix+" is permitted", cl.probeAbort(ctx, bucket, probe))
+			if cl.cfg.Prefix == nil {
+				result("deleting objects below "+prefix+" is permitted", cl.probeDelete(ctx, bucket, probe, versioned))
+			}
+		}
+	}
+
+	cl.println()
+	if failed > 0 {
+		cl.printf("Check failed: %d checks failed\n", failed)
+	} else {
+		cl.println("Check passed")
+	}
+	return failed
+}
+
+// checkRepositories returns an error unless there is at least one
+// repository below prefix.
+func (cl *Cleaner) checkRepositories(ctx context.Context, bucket, prefix string) error {
+	if len(cl.repositories) > 0 {
+		var missing []string
+		for _, r := range cl.repositories {
+			found, err := cl.prefixExists(ctx, bucket, prefix+r+"/")
+			if err != nil {
+				return err
+			}
+			if !found {
+				missing = append(missing, r)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("repositories not found: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+
+	resp, err := cl.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.CommonPrefixes) == 0 {
+		return errors.New("no repositories, check --bucket and --rootdir")
+	}
+	return nil
+}
+
+// probeAbort aborts an upload that doesn't exist. The backend answers
+// NoSuchUpload when aborting is permitted, AccessDenied when it isn't.
+func (cl *Cleaner) probeAbort(ctx context.Context, bucket, key string) error {
+	_, err := cl.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(probeUploadID),
+	})
+
+	if err == nil || isNoSuchUpload(err) {
+		return nil
+	}
+	return err
+}
+
+// probeDelete deletes an object that doesn't exist, which succeeds
+// without changing anything when deleting is permitted. In versioned
+// buckets the cleanup deletes versions, so the probe deletes the null
+// version, which also keeps it from leaving a delete marker.
+func (cl *Cleaner) probeDelete(ctx context.Context, bucket, key string, versioned bool) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if versioned {
+		input.VersionId = aws.String("null")
+	}
+
+	_, err := cl.client.DeleteObject(ctx, input)
+	if err != nil && isNotFound(err) {
+		return nil
+	}
+	return err
+}
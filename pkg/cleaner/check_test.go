@@ -0,0 +1,91 @@
+package cleaner
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// httpError returns the error the SDK returns for a response with status
+// and the error code.
+func httpError(status int, code string) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      &smithy.GenericAPIError{Code: code},
+	}}
+}
+
+func TestCheckProbes(t *testing.T) {
+	accessDenied := httpError(http.StatusForbidden, "AccessDenied")
+
+	tests := []struct {
+		name       string
+		abortErr   error
+		deleteErr  error
+		failed     int
+		failedLine string
+	}{
+		{"permitted", nil, nil, 0, ""},
+		{"abort answered NoSuchUpload", &types.NoSuchUpload{}, nil, 0, ""},
+		{"delete answered NotFound", nil, httpError(http.StatusNotFound, "NotFound"), 0, ""},
+		{"delete answered NoSuchKey", nil, &types.NoSuchKey{}, 0, ""},
+		{"abort denied", accessDenied, nil, 1, "FAIL  aborting multipart uploads"},
+		{"delete denied", nil, accessDenied, 1, "FAIL  deleting objects"},
+		{"both denied", accessDenied, accessDenied, 2, "FAIL  aborting multipart uploads"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			f.put(testRepositories+"repo/_layers/sha256/x/link", nil, time.Now())
+			f.fail["AbortMultipartUpload"] = []error{tt.abortErr}
+			f.fail["DeleteObject"] = []error{tt.deleteErr}
+
+			cl, out := newTestCleaner(t, f, Config{})
+			if failed := cl.Check(context.Background()); failed != tt.failed {
+				t.Errorf("%d checks failed, want %d\n%s", failed, tt.failed, out)
+			}
+			if tt.failedLine != "" && !strings.Contains(out.String(), tt.failedLine) {
+				t.Errorf("output without %q\n%s", tt.failedLine, out)
+			}
+			if n := len(f.keys()); n != 1 {
+				t.Errorf("check left %d objects, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCheckMissingRepositories(t *testing.T) {
+	f := newFakeS3()
+	f.put("docker/registry/v2/blobs/sha256/x/data", nil, time.Now())
+
+	cl, out := newTestCleaner(t, f, Config{})
+	if failed := cl.Check(context.Background()); failed != 1 {
+		t.Errorf("%d checks failed, want 1\n%s", failed, out)
+	}
+	for _, want := range []string{"FAIL  repositories found below " + testRepositories, "Check failed: 1 checks failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output without %q\n%s", want, out)
+		}
+	}
+}
+
+func TestCheckUnreachableBucket(t *testing.T) {
+	f := newFakeS3()
+	f.fail["HeadBucket"] = []error{&smithy.GenericAPIError{Code: "NoSuchBucket"}}
+
+	cl, out := newTestCleaner(t, f, Config{})
+	if failed := cl.Check(context.Background()); failed != 1 {
+		t.Errorf("%d checks failed, want 1\n%s", failed, out)
+	}
+	if n := len(f.callsOf("ListMultipartUploads")); n != 0 {
+		t.Errorf("%d more checks of an unreachable bucket, want none", n)
+	}
+}
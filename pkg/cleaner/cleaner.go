@@ -0,0 +1,1389 @@
+// Package cleaner removes stale multipart uploads and the upload folders
+// Docker registries leave behind from S3 buckets.
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const startedadDateFormat = "2006-01-02T15:04:05Z"
+
+// Layouts accepted for the content of startedat files, registry versions
+// differ in what they write.
+var startedatLayouts = []string{time.RFC3339Nano, time.RFC3339, startedadDateFormat}
+
+const repositoriesPrefix = "docker/registry/v2/repositories/"
+
+// Attempts made to read a startedat file, and to list a page of upload
+// folders, failing with a transient error.
+const (
+	startedatAttempts = 3
+	listAttempts      = 3
+)
+
+// startedat times further in the future than this are reported as clock
+// skew, smaller differences are normal between hosts.
+const futureDatedTolerance = 5 * time.Minute
+
+// Defaults used for zero Config fields.
+const (
+	DefaultMinThreshold = time.Hour
+	DefaultInactiveDays = 30
+)
+
+// Config configures a Cleaner. The fields match the s3-upload-cleaner
+// command line flags of the same name, which also describe them in detail.
+type Config struct {
+	// Endpoint is the S3 endpoint, empty for the AWS endpoint of Region.
+	// AutoRegion looks up the Region of the buckets, which is also done
+	// when both are empty.
+	Endpoint          string
+	Region            string
+	AutoRegion        bool
+	AddressingStyle   string // "path", "virtual" or "auto", default path with an Endpoint
+	SignatureVersion  string // "v4" (the default) or "v2"
+	UseDualstack      bool
+	UseFIPS           bool
+	CACert            string
+	Insecure          bool
+	ClientCert        string
+	ClientKey         string
+	ClientKeyPassword string
+
+	// Static credentials, the AWS credential chain is used without them.
+	// CredentialSource describes where they came from for the output.
+	AccessKey        string
+	SecretKey        string
+	CredentialSource string
+
+	// Debug logs the requests and responses of the S3 client to stderr,
+	// DebugHTTPBody including their bodies.
+	Debug         bool
+	DebugHTTPBody bool
+
+	// Proxy is the URL of the HTTP proxy requests go through, except for
+	// the hosts of NoProxy, the NO_PROXY format. Without Proxy the proxy
+	// environment variables apply, still except for the hosts of NoProxy.
+	Proxy   string
+	NoProxy string
+
+	// RequestTimeout bounds every HTTP request to the endpoint,
+	// DefaultRequestTimeout when zero. Requests timing out are retried
+	// like other network errors.
+	RequestTimeout time.Duration
+
+	// CopyTimeout bounds the CopyObject requests of the archive mode
+	// instead of RequestTimeout, DefaultCopyTimeout when zero.
+	CopyTimeout time.Duration
+
+	// MaxIdleConnsPerHost is the number of idle connections kept to the
+	// endpoint, Concurrency plus two when zero.
+	MaxIdleConnsPerHost int
+
+	// Client replaces the S3 client built from the settings above.
+	Client S3API
+
+	// Buckets to clean, entries can be comma separated lists.
+	Buckets     []string
+	RootDirs    []string
+	Prefix      *string
+	AllPrefixes bool
+
+	// Repositories limits the cleanup to these repositories, e.g.
+	// library/nginx, instead of every repository of the registry.
+	Repositories []string
+
+	// CleanupThreshold is the age after which uploads are removed. Runs
+	// with a threshold below MinThreshold (DefaultMinThreshold when zero)
+	// are refused unless Force or DryRun is set.
+	CleanupThreshold time.Duration
+	MinThreshold     time.Duration
+	Force            bool
+
+	// OlderThan is a fixed cutoff replacing CleanupThreshold: uploads
+	// started before it are removed, however long the run takes.
+	OlderThan time.Time
+
+	// Thresholds override the cleanup threshold for the repositories
+	// matching a pattern, as [bucket:]pattern=duration. The most specific
+	// matching pattern wins.
+	Thresholds []string
+
+	// MinSize skips stale multipart uploads with less than this many bytes
+	// uploaded, zero to abort them whatever their size.
+	MinSize int64
+
+	// IdleThreshold protects upload folders with an object modified more
+	// recently than this, zero to disable.
+	IdleThreshold time.Duration
+
+	InitiatorFilter      string
+	DryRun               bool
+	DryRunDetail         string // "summary" (the default) or "keys"
+	Estimate             bool
+	CleanOrphans         bool
+	FallbackLastModified bool
+	CleanFutureDated     bool
+	Versioned            bool
+
+	// CleanDeletedFolders finds the upload folders of versioned buckets
+	// with ListObjectVersions and removes the noncurrent versions and
+	// delete markers left of those whose objects were all deleted, e.g. by
+	// the registry when the upload completed.
+	CleanDeletedFolders bool
+
+	// PruneEmptyUploads removes upload folders holding nothing but
+	// directory placeholders, and the placeholder of an _uploads/
+	// directory once nothing is left below it.
+	PruneEmptyUploads bool
+
+	// TimeSource decides which time an upload folder's age is judged by:
+	// "startedat" (the default) for the content of its startedat file,
+	// "s3" for startedat's LastModified time, "oldest" or "newest" for the
+	// earlier or later of both. TimeSkew is the difference between them
+	// that is reported as clock skew, zero to never report it.
+	TimeSource string
+	TimeSkew   time.Duration
+
+	ArchivePrefix string
+	ArchiveBucket string
+	ArchiveDated  bool
+
+	// InactiveDays is DefaultInactiveDays when zero.
+	InactiveDays  int
+	AllowInactive bool
+
+	// Timeout stops the run after this long, zero for no limit.
+	Timeout time.Duration
+
+	// Lock makes the run hold a lock object in every bucket and root
+	// directory, so runs can't overlap. Locks older than LockTTL
+	// (DefaultLockTTL when zero) are left by crashed runs and taken over.
+	Lock    bool
+	LockTTL time.Duration
+
+	// ProgressInterval is how often a progress line is printed during the
+	// run, zero for never.
+	ProgressInterval time.Duration
+
+	// FailFast stops the run at the first error of an S3 request, after
+	// the retries of transient ones, instead of continuing with the next
+	// upload. Unreadable startedat files and malformed AbortList lines
+	// don't stop it.
+	FailFast bool
+
+	// AbortList is a file, "-" for stdin, of multipart uploads to abort
+	// instead of cleaning the bucket: one key and upload ID per line. The
+	// cleanup threshold only applies to them with RespectAge.
+	AbortList  string
+	RespectAge bool
+
+	// Concurrency is the number of DeleteObjects requests made at a time
+	// when removing a folder, DefaultConcurrency when zero.
+	Concurrency int
+
+	// AuditLog appends a JSON line for every multipart upload aborted and
+	// object deleted to this file. Dry runs don't write it.
+	AuditLog string
+
+	// Top limits the repository table and Report.Repositories, zero for
+	// all.
+	Top        int
+	ReportCSV  string
+	ReportHTML string
+
+	// List makes the run a dry run that writes only the candidates to the
+	// Output, the oldest first, in the ListFormat: "table" (the default),
+	// "json" or "csv".
+	List       bool
+	ListFormat string
+
+	// ListLimit limits the rows of List, zero for all.
+	ListLimit int
+
+	// Output receives the progress and summary of the run, os.Stdout when
+	// nil.
+	Output io.Writer
+}
+
+// Cleaner cleans the buckets of its Config.
+type Cleaner struct {
+	cfg      Config
+	client   S3API
+	endpoint string
+	buckets  []string
+	rootDirs []string
+	out      io.Writer
+
+	// thresholds are the parsed Config.Thresholds.
+	thresholds []thresholdRule
+
+	// listOut is the Output of a --list run, the log goes nowhere.
+	listOut io.Writer
+
+	// proxy is the Proxy function of the transport, proxyURL the proxy it
+	// picks for the endpoint, "" for none.
+	proxy    func(*http.Request) (*url.URL, error)
+	proxyURL string
+
+	// copyHTTPClient is the HTTP client of the CopyObject requests, nil
+	// with a Config.Client.
+	copyHTTPClient s3.HTTPClient
+
+	// detectedRegion is the region of the buckets found with AutoRegion.
+	detectedRegion string
+
+	// repositories are the Repositories to clean, without slashes around
+	// them, nil for all.
+	repositories []string
+
+	// initiatorFilter is the compiled InitiatorFilter, nil when not given.
+	initiatorFilter *regexp.Regexp
+
+	candidatesCSV *csvReport
+	auditLog      *auditLog
+	progress      progress
+	apiStats      apiStats
+
+	// halt stops the run with an error, set by Run.
+	halt context.CancelCauseFunc
+
+	// outMu serializes writes to out, the progress line is printed from
+	// its own goroutine.
+	outMu sync.Mutex
+}
+
+// New checks cfg and creates the S3 client. Setup errors are returned here,
+// before anything is listed.
+func New(cfg Config) (*Cleaner, error) {
+	cl := &Cleaner{cfg: cfg, out: cfg.Output}
+	if cl.out == nil {
+		cl.out = os.Stdout
+	}
+
+	if cl.cfg.Estimate {
+		cl.cfg.DryRun = true
+	}
+	// The log of a --list run is dropped, its candidates are printed at
+	// the end.
+	if cl.cfg.List {
+		cl.cfg.DryRun = true
+		cl.listOut, cl.out = cl.out, io.Discard
+	}
+	if cl.cfg.MinThreshold == 0 {
+		cl.cfg.MinThreshold = DefaultMinThreshold
+	}
+	if cl.cfg.InactiveDays == 0 {
+		cl.cfg.InactiveDays = DefaultInactiveDays
+	}
+	if cl.cfg.LockTTL == 0 {
+		cl.cfg.LockTTL = DefaultLockTTL
+	}
+	if cl.cfg.Concurrency <= 0 {
+		cl.cfg.Concurrency = DefaultConcurrency
+	}
+	if cl.cfg.RequestTimeout <= 0 {
+		cl.cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	if cl.cfg.CopyTimeout <= 0 {
+		cl.cfg.CopyTimeout = DefaultCopyTimeout
+	}
+	if cl.cfg.TimeSource == "" {
+		cl.cfg.TimeSource = "startedat"
+	}
+
+	threshold := cl.cfg.CleanupThreshold
+	if !cl.cfg.OlderThan.IsZero() {
+		if cl.cfg.CleanupThreshold != 0 {
+			return nil, errors.New("a cleanup threshold and an older-than cutoff can't be combined")
+		}
+		threshold = time.Since(cl.cfg.OlderThan)
+	}
+	if threshold < cl.cfg.MinThreshold && !cl.cfg.DryRun && !cl.cfg.Force {
+		cl.printf("WARNING: a cleanup threshold of %s would remove uploads that may still be in progress.\n", threshold.Round(time.Second))
+		return nil, fmt.Errorf("refusing to run with a threshold below %s; use --force or --dry-run", cl.cfg.MinThreshold)
+	}
+
+	for _, spec := range cl.cfg.Thresholds {
+		r, err := parseThresholdRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		if r.threshold < cl.cfg.MinThreshold && !cl.cfg.DryRun && !cl.cfg.Force {
+			cl.printf("WARNING: --threshold %s would remove uploads that may still be in progress.\n", spec)
+			return nil, fmt.Errorf("refusing to run with a threshold below %s; use --force or --dry-run", cl.cfg.MinThreshold)
+		}
+		cl.thresholds = append(cl.thresholds, r)
+	}
+
+	if cl.cfg.Client != nil {
+		cl.client = cl.cfg.Client
+		cl.endpoint = cl.cfg.Endpoint
+	} else {
+		ctx := context.Background()
+		s, err := cl.newS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if cl.cfg.AutoRegion || cl.cfg.Endpoint == "" && cl.cfg.Region == "" {
+			region, err := cl.bucketRegion(ctx, s)
+			if err != nil {
+				return nil, err
+			}
+			if region != s.Options().Region {
+				cl.cfg.Region = region
+				if s, err = cl.newS3Client(ctx); err != nil {
+					return nil, err
+				}
+			}
+			cl.detectedRegion = region
+		}
+
+		cl.endpoint, err = resolvedEndpoint(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("resolving the endpoint: %w", err)
+		}
+		cl.proxyURL = describeProxy(cl.proxy, cl.endpoint)
+
+		if cl.cfg.ClientCert != "" {
+			if err := checkClientCertificate(ctx, s.Options().HTTPClient, cl.endpoint); err != nil {
+				return nil, err
+			}
+		}
+		cl.client = s
+	}
+	cl.client = countingClient{S3API: cl.client, calls: &cl.progress.apiCalls, stats: &cl.apiStats}
+
+	if cl.cfg.AllPrefixes {
+		if cl.cfg.Prefix != nil || len(cl.cfg.RootDirs) > 0 {
+			return nil, errors.New("--all-prefixes can't be combined with --prefix or --rootdir")
+		}
+		cl.cfg.Prefix = aws.String("")
+	}
+
+	if cl.cfg.Prefix != nil && len(cl.cfg.RootDirs) > 0 {
+		return nil, errors.New("--prefix and --rootdir are mutually exclusive")
+	}
+
+	for _, r := range cl.cfg.Repositories {
+		r = strings.Trim(r, "/")
+		if r == "" {
+			return nil, errors.New("empty --repository")
+		}
+		cl.repositories = append(cl.repositories, r)
+	}
+	if len(cl.repositories) > 0 && cl.cfg.Prefix != nil {
+		return nil, errors.New("--repository can't be combined with --prefix or --all-prefixes")
+	}
+
+	cl.buckets = bucketNames(cl.cfg.Buckets)
+	if len(cl.buckets) == 0 {
+		return nil, errors.New("no bucket given")
+	}
+
+	for _, r := range cl.thresholds {
+		if r.bucket != "" && !slices.Contains(cl.buckets, r.bucket) {
+			cl.printf("WARNING: --threshold %s is for bucket %s, which isn't cleaned\n", r.spec, r.bucket)
+		}
+	}
+
+	if cl.cfg.AbortList != "" {
+		if len(cl.buckets) > 1 {
+			return nil, errors.New("--abort-list takes a single --bucket")
+		}
+		if cl.cfg.Prefix != nil || len(cl.cfg.RootDirs) > 0 || len(cl.repositories) > 0 {
+			return nil, errors.New("--abort-list can't be combined with --prefix, --all-prefixes, --rootdir or --repository")
+		}
+	} else if cl.cfg.RespectAge {
+		return nil, errors.New("--respect-age only applies to --abort-list")
+	}
+
+	var err error
+	cl.rootDirs, err = rootDirectories(cl.cfg.RootDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if cl.cfg.InitiatorFilter != "" {
+		cl.initiatorFilter, err = regexp.Compile(cl.cfg.InitiatorFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --initiator-filter: %w", err)
+		}
+	}
+
+	return cl, nil
+}
+
+// Run cleans every bucket and root directory, printing the progress to the
+// Output. Errors limited to a bucket, prefix or upload are counted in the
+// Report; the returned error means the run couldn't be done at all: the
+// CSV report or the audit log can't be created, the first listing failed
+// with an error about the credentials or the connection, the listing of
+// every bucket failed, or writing the audit log failed and the run was
+// stopped.
+func (cl *Cleaner) Run(ctx context.Context) (Report, error) {
+	started := time.Now()
+	if cl.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cl.cfg.Timeout)
+		defer cancel()
+	}
+	ctx, cl.halt = context.WithCancelCause(ctx)
+	defer cl.halt(nil)
+
+	labelled := len(cl.buckets)*len(cl.rootDirs) > 1
+
+	if cl.cfg.ReportCSV != "" {
+		var err error
+		cl.candidatesCSV, err = openCSVReport(cl.cfg.ReportCSV, cl.out)
+		if err != nil {
+			return Report{}, fmt.Errorf("creating CSV report: %w", err)
+		}
+	}
+
+	// Dry runs remove nothing, so there is nothing to audit.
+	if cl.cfg.AuditLog != "" && !cl.cfg.DryRun {
+		var err error
+		cl.auditLog, err = openAuditLog(cl.cfg.AuditLog)
+		if err != nil {
+			cl.candidatesCSV.close()
+			return Report{}, fmt.Errorf("opening audit log: %w", err)
+		}
+		defer cl.auditLog.close(cl.out)
+	}
+
+	cl.printf("Endpoint: %s\n", cl.endpoint)
+	if cl.detectedRegion != "" {
+		cl.printf("Region: %s (detected from the bucket location)\n", cl.detectedRegion)
+	}
+	if cl.proxyURL != "" {
+		cl.printf("Proxy: %s\n", cl.proxyURL)
+	}
+	cl.printf("Bucket: %s\n", strings.Join(cl.buckets, ", "))
+	if len(cl.cfg.RootDirs) > 0 {
+		cl.printf("Root directories: %s\n", strings.Join(rootDirLabels(cl.rootDirs), ", "))
+	}
+	if cl.cfg.AllPrefixes {
+		cl.println("Prefix: whole bucket (only multipart uploads are cleaned)")
+		cl.println("WARNING: --all-prefixes aborts stale multipart uploads of every key in the bucket, also outside the registry.")
+	} else if cl.cfg.Prefix != nil {
+		cl.printf("Prefix: %q (only multipart uploads are cleaned)\n", *cl.cfg.Prefix)
+	} else if cl.cfg.AbortList != "" {
+		cl.printf("Abort list: %s (only the listed multipart uploads are aborted)\n", cl.cfg.AbortList)
+	}
+	if len(cl.repositories) > 0 {
+		cl.printf("Repositories: %s\n", strings.Join(cl.repositories, ", "))
+	}
+	if cl.initiatorFilter != nil {
+		cl.printf("Initiator filter: %s\n", cl.initiatorFilter)
+	}
+	cl.printf("Credentials: %s\n", cl.cfg.CredentialSource)
+	if cl.cfg.AbortList != "" && !cl.cfg.RespectAge {
+		cl.println("Cleanup threshold: not applied to the abort list (see --respect-age)")
+	} else {
+		cl.printf("Cleanup threshold: %s\n", cl.describeThreshold())
+	}
+	if len(cl.thresholds) > 0 {
+		specs := make([]string, 0, len(cl.thresholds))
+		for _, r := range cl.thresholds {
+			specs = append(specs, r.spec)
+		}
+		cl.printf("Threshold overrides: %s\n", strings.Join(specs, ", "))
+	}
+	if cl.cfg.Estimate {
+		cl.println("Estimate: nothing will be removed")
+	} else if cl.cfg.DryRun {
+		cl.println("Dry run: nothing will be removed")
+	}
+	cl.println()
+
+	if cl.cfg.Lock {
+		locks, err := cl.acquireLocks(ctx, started)
+		if err != nil {
+			cl.candidatesCSV.close()
+			cl.printf("ERROR: %s\n", err)
+			return Report{}, err
+		}
+		defer cl.releaseLocks(locks)
+	}
+
+	cl.warnUnmatchedThresholds(ctx)
+	stopProgress := cl.reportProgress(started)
+
+	var summaries []*runSummary
+	var fatal, listingFailed error
+	listingsFailed := 0
+	for _, bucket := range cl.buckets {
+		for _, rootDir := range cl.rootDirs {
+			summary := &runSummary{bucket: bucket, rootDir: rootDir}
+			summaries = append(summaries, summary)
+
+			if labelled {
+				cl.printf("=== %s ===\n\n", cl.label(summary))
+			}
+
+			if err := cl.cleanBucket(ctx, summary); err != nil {
+				cl.printf("ERROR: %s\n", err)
+				cl.addError(summary, err)
+				switch errorType(err) {
+				case "SignatureDoesNotMatch":
+					cl.printf("HINT: the endpoint rejected the request signature, check the secret key, or try --signature-version %s\n", cl.otherSignatureVersion())
+				case "ProxyError":
+					cl.printf("HINT: connecting to the proxy %s failed, check --proxy or the HTTPS_PROXY environment variable\n", cl.proxyURL)
+				case "NetworkError":
+					cl.printf("HINT: connecting to the endpoint %s failed\n", cl.endpoint)
+				}
+
+				// Wrong credentials or an unreachable endpoint would fail
+				// the other buckets the same way; anything else, like
+				// NoSuchBucket or AccessDenied, is limited to this bucket.
+				var listErr *listingError
+				if errors.As(err, &listErr) {
+					listingsFailed++
+					if listingFailed == nil {
+						listingFailed = err
+					}
+					if isGlobalError(err) {
+						fatal = err
+					}
+				}
+			}
+
+			summary.stopCause = context.Cause(ctx)
+			cl.printSummary(summary, labelled)
+			if labelled {
+				cl.println()
+			}
+
+			if ctx.Err() != nil || fatal != nil {
+				break
+			}
+		}
+
+		if ctx.Err() != nil || fatal != nil {
+			break
+		}
+	}
+
+	stopProgress()
+
+	if fatal == nil && listingsFailed > 0 && listingsFailed == len(summaries) {
+		fatal = listingFailed
+	}
+
+	cl.printTotals(summaries)
+	cl.printRepositories(summaries)
+	cl.printErrorSummary(summaries)
+	cl.printAPICalls(time.Since(started))
+	cl.candidatesCSV.close()
+
+	if cl.cfg.List {
+		cl.printList(summaries)
+	}
+
+	if cl.cfg.ReportHTML != "" {
+		cl.writeHTMLReport(cl.cfg.ReportHTML, started, summaries)
+	}
+
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errAuditLog) {
+		fatal = cause
+	}
+
+	report := cl.newReport(started, summaries)
+	report.TimedOut = errors.Is(cause, context.DeadlineExceeded)
+	report.Interrupted = errors.Is(cause, context.Canceled)
+	report.FailedFast = errors.Is(cause, errFailFast)
+	return report, fatal
+}
+
+func (cl *Cleaner) printf(format string, a ...interface{}) {
+	cl.outMu.Lock()
+	defer cl.outMu.Unlock()
+	fmt.Fprintf(cl.out, format, a...)
+}
+
+func (cl *Cleaner) println(a ...interface{}) {
+	cl.outMu.Lock()
+	defer cl.outMu.Unlock()
+	fmt.Fprintln(cl.out, a...)
+}
+
+// bucketNames returns the bucket names in names, splitting comma separated
+// lists.
+func bucketNames(names []string) []string {
+	var buckets []string
+	for _, b := range names {
+		for _, name := range strings.Split(b, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				buckets = append(buckets, name)
+			}
+		}
+	}
+	return buckets
+}
+
+// rootDirectories returns the registry root directories given with
+// --rootdir, without leading and trailing slashes. An empty root directory
+// is the bucket root. Root directories whose repositories prefixes overlap
+// would have their uploads processed twice and are rejected.
+func rootDirectories(dirs []string) ([]string, error) {
+	if len(dirs) == 0 {
+		return []string{""}, nil
+	}
+
+	var rootDirs []string
+	for _, r := range dirs {
+		r = strings.Trim(r, "/")
+
+		for _, other := range rootDirs {
+			a, b := repositoriesPath(r), repositoriesPath(other)
+			if strings.HasPrefix(a, b) || strings.HasPrefix(b, a) {
+				return nil, fmt.Errorf("root directories %q and %q overlap", r, other)
+			}
+		}
+
+		rootDirs = append(rootDirs, r)
+	}
+
+	return rootDirs, nil
+}
+
+// repositoriesPath returns the repositories prefix of the registry stored
+// in rootDir.
+func repositoriesPath(rootDir string) string {
+	if rootDir == "" {
+		return repositoriesPrefix
+	}
+	return rootDir + "/" + repositoriesPrefix
+}
+
+func rootDirLabels(rootDirs []string) []string {
+	labels := make([]string, 0, len(rootDirs))
+	for _, r := range rootDirs {
+		if r == "" {
+			r = "(bucket root)"
+		}
+		labels = append(labels, r)
+	}
+	return labels
+}
+
+// cleanBucket runs the whole cleanup on the registry stored in
+// summary.rootDir of summary.bucket. Errors that make the
+// rest of the bucket unreachable are returned, errors limited to a single
+// prefix are recorded in the summary and the cleanup continues.
+func (cl *Cleaner) cleanBucket(ctx context.Context, summary *runSummary) error {
+	if cl.cfg.AbortList != "" {
+		return cl.abortListed(ctx, summary)
+	}
+	if cl.cfg.Prefix != nil {
+		return cl.cleanPrefix(ctx, summary)
+	}
+
+	bucket := summary.bucket
+	prefix := repositoriesPath(summary.rootDir)
+
+	summary.versioned = cl.bucketVersioned(ctx, bucket)
+
+	// Upload folders are listed below the whole repositories tree, or
+	// below each of the --repository prefixes.
+	folderPrefixes := []string{prefix}
+
+	var commonPrefixes []types.CommonPrefix
+	if len(cl.repositories) > 0 {
+		folderPrefixes = nil
+		for _, r := range cl.repositories {
+			repoPrefix := prefix + r + "/"
+			found, err := cl.prefixExists(ctx, bucket, repoPrefix)
+			if err != nil {
+				if ctx.Err() != nil {
+					summary.stoppedAt = "listing " + repoPrefix
+					return nil
+				}
+				return &listingError{fmt.Errorf("listing %s: %w", repoPrefix, err)}
+			}
+			if !found {
+				cl.printf("WARNING: repository %s not found, no keys below %s\n", r, repoPrefix)
+				continue
+			}
+			commonPrefixes = append(commonPrefixes, types.CommonPrefix{Prefix: aws.String(repoPrefix)})
+			folderPrefixes = append(folderPrefixes, repoPrefix)
+		}
+	} else {
+		paginator := s3.NewListObjectsV2Paginator(cl.client, &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Prefix:    aws.String(prefix),
+			Delimiter: aws.String("/"),
+		})
+
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					summary.stoppedAt = "listing " + prefix
+					return nil
+				}
+				return &listingError{fmt.Errorf("listing %s: %w", prefix, err)}
+			}
+			commonPrefixes = append(commonPrefixes, page.CommonPrefixes...)
+		}
+	}
+	cl.progress.prefixesFound.Add(int64(len(commonPrefixes)))
+
+	summary.activity = cl.checkRegistryActivity(ctx, bucket, prefix, commonPrefixes)
+	if summary.activity.inactive(cl.cfg.InactiveDays) && !cl.cfg.DryRun && !cl.cfg.AllowInactive {
+		return fmt.Errorf("refusing to remove uploads from an inactive registry; use --allow-inactive or --dry-run")
+	}
+
+	for i, cp := range commonPrefixes {
+		cl.printf("Prefix %d: %s\n", i, *cp.Prefix)
+
+		removed, err := cl.cleanMPUs(ctx, summary, *cp.Prefix)
+		summary.mpusRemoved += removed
+		cl.printf("  Total MPUs removed: %d\n", summary.mpusRemoved)
+
+		if ctx.Err() != nil {
+			summary.stoppedAt = "MPU cleanup of " + *cp.Prefix
+			return nil
+		}
+
+		if err != nil {
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+		cl.progress.prefixesDone.Add(1)
+	}
+
+	cl.println()
+	cl.println("Removing upload folders:")
+	for _, folderPrefix := range folderPrefixes {
+		err := cl.cleanUploadFolders(ctx, summary, folderPrefix)
+
+		if ctx.Err() != nil {
+			summary.stoppedAt = "upload folder cleanup of " + folderPrefix
+			return nil
+		}
+
+		if err != nil {
+			cl.printf("ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+	}
+
+	return nil
+}
+
+// prefixExists reports whether there is any key below prefix.
+func (cl *Cleaner) prefixExists(ctx context.Context, bucket, prefix string) (bool, error) {
+	resp, err := cl.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Contents) > 0, nil
+}
+
+// cleanPrefix is the generic mode used with --prefix and --all-prefixes.
+// Only stale multipart uploads below the prefix are aborted, the
+// _uploads/<id>/startedat layout used to find upload folders is specific to
+// the registry.
+func (cl *Cleaner) cleanPrefix(ctx context.Context, summary *runSummary) error {
+	prefix := *cl.cfg.Prefix
+	if cl.cfg.AllPrefixes {
+		cl.printf("Sweeping multipart uploads of all keys in bucket %s:\n", summary.bucket)
+	}
+
+	cl.progress.prefixesFound.Add(1)
+	removed, err := cl.cleanMPUs(ctx, summary, prefix)
+	summary.mpusRemoved += removed
+	cl.progress.prefixesDone.Add(1)
+
+	if ctx.Err() != nil {
+		summary.stoppedAt = fmt.Sprintf("MPU cleanup of %q", prefix)
+		return nil
+	}
+
+	return err
+}
+
+func (cl *Cleaner) cleanMPUs(ctx context.Context, summary *runSummary, prefix string) (totalRemoved int, err error) {
+	totalRemoved = 0
+	bucket := summary.bucket
+
+	uploads, err := cl.listMultipartUploads(ctx, bucket, prefix)
+	if err != nil {
+		err = &listingError{fmt.Errorf("listing multipart uploads of %s: %w", prefix, err)}
+		return
+	}
+
+	cl.printf(" # of MPUs found for prefix: %d\n", len(uploads))
+
+	for i, multi := range uploads {
+		if ctx.Err() != nil {
+			cl.printf("  Stopped before upload %d: %s\n", i, *multi.Key)
+			return
+		}
+
+		cl.printf("  Upload %d: %s\n", i, *multi.Key)
+		cl.printf("  Initiator: %s, owner: %s\n", describeInitiator(multi.Initiator), describeOwner(multi.Owner))
+
+		hoursSince := int(time.Since(*multi.Initiated).Hours())
+
+		cl.printf("  Started %d hours ago%s\n", hoursSince, cl.thresholdNote(bucket, *multi.Key))
+
+		c := candidate{
+			kind:     "mpu",
+			bucket:   bucket,
+			key:      *multi.Key,
+			uploadID: aws.ToString(multi.UploadId),
+			started:  *multi.Initiated,
+			hours:    hoursSince,
+			size:     -1,
+			action:   actionSkipped,
+		}
+
+		if !cl.initiatorMatches(multi.Initiator) {
+			cl.println("   Skipped, initiator doesn't match --initiator-filter")
+			summary.mpusFiltered++
+			cl.record(summary, c)
+			continue
+		}
+
+		if cl.stale(*multi.Initiated, bucket, *multi.Key) {
+			// Only look up the size when it decides anything, it takes
+			// ListParts calls.
+			if cl.cfg.MinSize > 0 {
+				size, err := cl.uploadedPartsSize(ctx, bucket, multi)
+				if err != nil {
+					cl.printf(" ERROR: %s\n", err)
+					cl.addError(summary, err)
+					c.action = actionError
+					cl.record(summary, c)
+					continue
+				}
+				c.size = size
+				if size < cl.cfg.MinSize {
+					cl.printf("   Skipped: below size threshold (%s uploaded)\n", FormatBytes(size))
+					summary.mpusSmall++
+					cl.record(summary, c)
+					continue
+				}
+			}
+
+			if cl.cfg.DryRun {
+				cl.println("   Would be removed")
+				if cl.cfg.Estimate && c.size < 0 {
+					size, err := cl.uploadedPartsSize(ctx, bucket, multi)
+					if err != nil {
+						cl.printf(" ERROR: %s\n", err)
+						cl.addError(summary, err)
+					} else {
+						cl.printf("   %s uploaded\n", FormatBytes(size))
+						c.size = size
+					}
+				}
+				totalRemoved++
+				c.action = actionWouldRemove
+				cl.record(summary, c)
+				continue
+			}
+
+			entry := auditEntry{
+				Time:     time.Now().UTC(),
+				Action:   "abort_mpu",
+				Bucket:   bucket,
+				Key:      aws.ToString(multi.Key),
+				UploadID: aws.ToString(multi.UploadId),
+			}
+			if !cl.auditReady() {
+				cl.record(summary, c)
+				return
+			}
+			_, err := cl.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      multi.Key,
+				UploadId: multi.UploadId,
+			})
+			cl.audit(auditOutcome(entry, err))
+
+			if err != nil {
+				cl.printf(" ERROR: %s\n", err)
+				cl.addError(summary, err)
+				c.action = actionError
+			} else {
+				cl.println("   Removed!")
+				totalRemoved++
+				c.action = actionRemoved
+			}
+		}
+
+		cl.record(summary, c)
+	}
+
+	return
+}
+
+// stale reports whether an upload started at started is older than the
+// cleanup threshold.
+func (cl *Cleaner) stale(started time.Time, bucket, key string) bool {
+	if r := cl.thresholdRule(bucket, key); r != nil {
+		return exceeds(time.Since(started), r.threshold)
+	}
+	if !cl.cfg.OlderThan.IsZero() {
+		return started.Before(cl.cfg.OlderThan)
+	}
+	return exceeds(time.Since(started), cl.cfg.CleanupThreshold)
+}
+
+// exceeds reports whether age is over threshold. Thresholds of whole hours,
+// which includes every --cleanup value, compare the age in whole hours,
+// like the hours in the log: with 12 hours an upload is removed from 13
+// hours on, not at 12 hours and a minute.
+func exceeds(age, threshold time.Duration) bool {
+	if threshold%time.Hour == 0 {
+		return age.Truncate(time.Hour) > threshold
+	}
+	return age > threshold
+}
+
+// describeThreshold returns the cleanup threshold for the banner and the
+// reports.
+func (cl *Cleaner) describeThreshold() string {
+	if !cl.cfg.OlderThan.IsZero() {
+		return "started before " + cl.cfg.OlderThan.Format(time.RFC3339)
+	}
+	return cl.cfg.CleanupThreshold.String()
+}
+
+// uploadedPartsSize adds up the size of the parts uploaded so far to the
+// multipart upload.
+func (cl *Cleaner) uploadedPartsSize(ctx context.Context, bucket string, multi types.MultipartUpload) (int64, error) {
+	var size int64
+	paginator := s3.NewListPartsPaginator(cl.client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      multi.Key,
+		UploadId: multi.UploadId,
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("listing parts of %s: %w", *multi.Key, err)
+		}
+
+		for _, part := range page.Parts {
+			size += aws.ToInt64(part.Size)
+		}
+	}
+
+	return size, nil
+}
+
+// initiatorMatches reports whether the upload started by initiator passes
+// --initiator-filter.
+func (cl *Cleaner) initiatorMatches(initiator *types.Initiator) bool {
+	if cl.initiatorFilter == nil {
+		return true
+	}
+	if initiator == nil {
+		return false
+	}
+	return cl.initiatorFilter.MatchString(aws.ToString(initiator.ID)) ||
+		cl.initiatorFilter.MatchString(aws.ToString(initiator.DisplayName))
+}
+
+func describeInitiator(initiator *types.Initiator) string {
+	if initiator == nil {
+		return "unknown"
+	}
+	return describeIdentity(aws.ToString(initiator.ID), aws.ToString(initiator.DisplayName))
+}
+
+func describeOwner(owner *types.Owner) string {
+	if owner == nil {
+		return "unknown"
+	}
+	return describeIdentity(aws.ToString(owner.ID), aws.ToString(owner.DisplayName))
+}
+
+func describeIdentity(id, name string) string {
+	switch {
+	case id == "" && name == "":
+		return "unknown"
+	case name == "" || name == id:
+		return id
+	case id == "":
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, id)
+}
+
+// listMultipartUploads lists every multipart upload below prefix. Pages are
+// keyed by both the key marker and the upload ID marker: when a single key
+// has more uploads than fit in a page, the next page continues within that
+// key, so carrying only the key marker forward would repeat or skip uploads.
+func (cl *Cleaner) listMultipartUploads(ctx context.Context, bucket, prefix string) ([]types.MultipartUpload, error) {
+	var uploads []types.MultipartUpload
+
+	input := &s3.ListMultipartUploadsInput{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(prefix),
+		MaxUploads: aws.Int32(1000),
+	}
+
+	for {
+		page, err := cl.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return uploads, err
+		}
+
+		uploads = append(uploads, page.Uploads...)
+
+		if !aws.ToBool(page.IsTruncated) {
+			return uploads, nil
+		}
+
+		if aws.ToString(page.NextKeyMarker) == aws.ToString(input.KeyMarker) &&
+			aws.ToString(page.NextUploadIdMarker) == aws.ToString(input.UploadIdMarker) {
+			return uploads, errors.New("pagination markers did not advance")
+		}
+
+		input.KeyMarker = page.NextKeyMarker
+		input.UploadIdMarker = page.NextUploadIdMarker
+	}
+}
+
+func (cl *Cleaner) cleanUploadFolders(ctx context.Context, summary *runSummary, prefix string) error {
+	paginator := cl.newFolderLister(summary, prefix)
+
+	// Keys are listed in lexical order, so the objects of an upload folder
+	// are contiguous and the folder can be handled once the listing moves
+	// past it.
+	var folder *uploadFolder
+
+	// Placeholders of _uploads/ directories, pruned once their folders
+	// are handled.
+	var uploadsDirs []string
+
+	for paginator.HasMorePages() {
+		// A failed page leaves the paginator where it was, so calling
+		// NextPage again repeats the same request.
+		entries, err := retryTransient(ctx, listAttempts, func() ([]folderEntry, error) {
+			return paginator.NextPage(ctx)
+		})
+		if err != nil {
+			// The folder being collected may have more objects on the
+			// page that failed, so it is left for the next run.
+			return fmt.Errorf("listing %s: %w", prefix, err)
+		}
+
+		for _, o := range entries {
+			if cl.cfg.PruneEmptyUploads && o.current && strings.HasSuffix(*o.Key, uploadsDir) && aws.ToInt64(o.Size) == 0 {
+				uploadsDirs = append(uploadsDirs, *o.Key)
+			}
+
+			path := uploadFolderPath(*o.Key)
+			if path == "" {
+				continue
+			}
+
+			if folder != nil && folder.path != path {
+				if ctx.Err() != nil {
+					cl.printf("  Stopped before folder %s\n", folder.path)
+					return nil
+				}
+				cl.cleanUploadFolder(ctx, summary, folder)
+				folder = nil
+			}
+
+			if folder == nil {
+				folder = &uploadFolder{path: path}
+			}
+			folder.addEntry(o)
+		}
+	}
+
+	if folder != nil {
+		if ctx.Err() != nil {
+			cl.printf("  Stopped before folder %s\n", folder.path)
+			return nil
+		}
+		cl.cleanUploadFolder(ctx, summary, folder)
+	}
+
+	for _, dir := range uploadsDirs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		cl.pruneUploadsDir(ctx, summary, dir)
+	}
+
+	return nil
+}
+
+// cleanUploadFolder removes folder when the upload it belongs to was started
+// more than the cleanup threshold ago.
+func (cl *Cleaner) cleanUploadFolder(ctx context.Context, summary *runSummary, folder *uploadFolder) {
+	if folder.objects == 0 && folder.placeholders == 0 {
+		cl.cleanDeletedFolder(ctx, summary, folder)
+		return
+	}
+	if folder.objects == 0 && cl.cfg.PruneEmptyUploads {
+		cl.cleanEmptyFolder(ctx, summary, folder)
+		return
+	}
+	if folder.startedat == "" {
+		cl.cleanOrphanFolder(ctx, summary, folder)
+		return
+	}
+
+	started, source, err := cl.uploadAge(ctx, summary.bucket, folder)
+	if isNotFound(err) {
+		// Usually a folder half deleted by an interrupted run.
+		cl.printf("  startedat of %s not found, treating the folder as an orphan\n", folder.path)
+		summary.startedatNotFound++
+		folder.startedat = ""
+		cl.cleanOrphanFolder(ctx, summary, folder)
+		return
+	}
+
+	c := folder.candidate(summary.bucket, "folder")
+	defer func() { cl.record(summary, c) }()
+
+	if err != nil {
+		cl.printf(" ERROR: %s\n", err)
+		cl.addError(summary, err)
+		c.action = actionError
+		return
+	}
+
+	if ahead := time.Until(started); ahead > futureDatedTolerance {
+		cl.printf("  WARNING: future-dated startedat %s (%s ahead), check clock skew on registry hosts\n",
+			folder.startedat, ahead.Round(time.Second))
+		summary.futureDated++
+		if !cl.cfg.CleanFutureDated || folder.startedatModified.IsZero() {
+			c.started = started
+			return
+		}
+		started, source = folder.startedatModified, "LastModified"
+	}
+
+	hoursSince := int(time.Since(started).Hours())
+	c.started, c.hours = started, hoursSince
+
+	age := fmt.Sprintf("%d hours", hoursSince)
+	if cl.cfg.FallbackLastModified || cl.cfg.TimeSource != "startedat" || source == "LastModified" {
+		age += ", from " + source
+	}
+	age += cl.thresholdNote(summary.bucket, folder.path)
+
+	if !cl.stale(started, summary.bucket, folder.path) {
+		cl.printf("  Skipping folder %s (%s)\n", folder.startedat, age)
+		return
+	}
+
+	if idle := time.Since(folder.newest); cl.cfg.IdleThreshold > 0 && idle < cl.cfg.IdleThreshold {
+		cl.printf("  Folder %s (%s) recently active, skipped: last modified %s ago\n",
+			folder.startedat, age, idle.Round(time.Second))
+		summary.activeSkipped++
+		return
+	}
+
+	if cl.cfg.DryRun {
+		cl.printf("  Would remove folder %s (%s)\n", folder.startedat, age)
+		summary.placeholdersRemoved += folder.placeholders
+		if cl.cfg.DryRunDetail == "keys" {
+			size, err := cl.previewFolder(ctx, summary, folder.path)
+			if err != nil {
+				cl.printf(" ERROR: %s\n", err)
+				cl.addError(summary, err)
+				c.action = actionError
+				return
+			}
+			c.size = size
+		}
+		c.action = actionWouldRemove
+	} else {
+		cl.printf("  Removing folder %s (%s)\n", folder.startedat, age)
+		left, err := cl.removeUploadFolder(ctx, summary, folder.startedat)
+		if err != nil {
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+		if left > 0 {
+			cl.printFolderLeft(summary, folder.path, left)
+			c.action = actionPartial
+			return
+		}
+		if err != nil {
+			c.action = actionError
+			return
+		}
+		c.action = actionRemoved
+	}
+	summary.foldersRemoved++
+}
+
+func (cl *Cleaner) removeUploadFolder(ctx context.Context, summary *runSummary, prefix string) (int, error) {
+	keyParts := strings.Split(prefix, "/")
+	uploadsFolder := strings.Join(keyParts[0:len(keyParts)-1], "/") + "/"
+
+	return cl.removeFolder(ctx, summary, uploadsFolder)
+}
+
+// removeFolder deletes every object below prefix, after copying it to the
+// archive with --archive-prefix. It returns the number of objects that
+// couldn't be deleted, zero when the folder is gone.
+func (cl *Cleaner) removeFolder(ctx context.Context, summary *runSummary, prefix string) (int, error) {
+	objects, err := cl.folderObjects(ctx, summary, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cl.archiveObjects(ctx, summary, objects); err != nil {
+		return 0, err
+	}
+
+	return cl.deleteKeys(ctx, summary, objects)
+}
+
+// printFolderLeft reports a folder that was only partially removed. What
+// is left is removed by the next run, or listed as undeletable again.
+func (cl *Cleaner) printFolderLeft(summary *runSummary, prefix string, left int) {
+	cl.printf("  Folder %s partially removed, %d objects left\n", prefix, left)
+	summary.foldersPartial++
+}
+
+// previewFolder prints the objects removeFolder would delete below prefix,
+// for --dry-run-detail keys, and returns their total size.
+func (cl *Cleaner) previewFolder(ctx context.Context, summary *runSummary, prefix string) (int64, error) {
+	objects, err := cl.folderObjects(ctx, summary, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	var placeholders []objectVersion
+	for _, o := range objects {
+		if o.placeholder() {
+			placeholders = append(placeholders, o)
+			continue
+		}
+		cl.printf("    Would delete %s (%s)\n", o, FormatBytes(o.size))
+		size += o.size
+	}
+	for _, o := range placeholders {
+		cl.printf("    Would delete placeholder %s\n", o)
+	}
+	return size, nil
+}
+
+// folderObjects lists the objects to delete to remove the folder prefix.
+// In versioned buckets these are every version and delete marker,
+// otherwise deleting would only add a delete marker and reclaim nothing.
+// prefix is a folder: without its trailing slash, a _uploads/abc prefix
+// would also match _uploads/abcdef/ of another upload.
+func (cl *Cleaner) folderObjects(ctx context.Context, summary *runSummary, prefix string) ([]objectVersion, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if summary.versioned {
+		return cl.folderVersions(ctx, summary, prefix)
+	}
+
+	var objects []objectVersion
+	paginator := s3.NewListObjectsV2Paginator(cl.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(summary.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		objs, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range objs.Contents {
+			if !cl.inFolder(summary, prefix, *o.Key) {
+				continue
+			}
+			objects = append(objects, objectVersion{key: *o.Key, size: aws.ToInt64(o.Size), current: true})
+		}
+	}
+
+	return objects, nil
+}
+
+// uploadStartedAt returns the time stored in the startedat file key.
+func (cl *Cleaner) uploadStartedAt(ctx context.Context, bucket, key string) (time.Time, error) {
+	obj, err := retryTransient(ctx, startedatAttempts, func() (*s3.GetObjectOutput, error) {
+		return cl.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+	})
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading %s: %w", key, err)
+	}
+
+	defer obj.Body.Close()
+	t, err := parseTimeFromStream(obj.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("skipping folder of %s: %w", key, err)
+	}
+
+	return t, nil
+}
+
+// inFolder checks that a key listed below the folder prefix actually
+// starts with it before it is deleted, recording an error for any other.
+func (cl *Cleaner) inFolder(summary *runSummary, prefix, key string) bool {
+	if strings.HasPrefix(key, prefix) {
+		return true
+	}
+
+	err := fmt.Errorf("listing of %s returned %s, which is outside the folder; not removing it", prefix, key)
+	cl.printf("    ERROR: %s\n", err)
+	cl.addError(summary, err)
+	return false
+}
+
+func parseTimeFromStream(s io.Reader) (time.Time, error) {
+	buf := new(bytes.Buffer)
+
+	_, err := buf.ReadFrom(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	dateString := strings.TrimSpace(buf.String())
+	for _, layout := range startedatLayouts {
+		if t, err := time.Parse(layout, dateString); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w %q", errUnrecognizedStartedAt, buf.String())
+}
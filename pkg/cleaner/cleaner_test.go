@@ -0,0 +1,245 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func TestExceeds(t *testing.T) {
+	tests := []struct {
+		age, threshold time.Duration
+		want           bool
+	}{
+		{12 * time.Hour, 12 * time.Hour, false},
+		{12*time.Hour + time.Minute, 12 * time.Hour, false},
+		{12*time.Hour + 59*time.Minute, 12 * time.Hour, false},
+		{13 * time.Hour, 12 * time.Hour, true},
+		{89 * time.Minute, 90 * time.Minute, false},
+		{90 * time.Minute, 90 * time.Minute, false},
+		{90*time.Minute + time.Second, 90 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		if got := exceeds(tt.age, tt.threshold); got != tt.want {
+			t.Errorf("exceeds(%s, %s) = %v, want %v", tt.age, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestStaleBoundary(t *testing.T) {
+	cl := &Cleaner{cfg: Config{CleanupThreshold: 12 * time.Hour}}
+	now := time.Now()
+
+	tests := []struct {
+		age  time.Duration
+		want bool
+	}{
+		{11 * time.Hour, false},
+		{12*time.Hour + time.Minute, false},
+		{12*time.Hour + 59*time.Minute, false},
+		{13*time.Hour + time.Minute, true},
+	}
+	for _, tt := range tests {
+		if got := cl.stale(now.Add(-tt.age), "b", "k"); got != tt.want {
+			t.Errorf("stale(%s ago) = %v, want %v", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestListMultipartUploadsOfOneKey(t *testing.T) {
+	f := newFakeS3()
+	key := testRepositories + "repo/_uploads/u/data"
+	for i := 0; i < 2500; i++ {
+		f.putMultipartUpload(key, fmt.Sprintf("id%05d", i), time.Now())
+	}
+
+	cl, _ := newTestCleaner(t, f, Config{})
+	uploads, err := cl.listMultipartUploads(context.Background(), "bucket", testRepositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, u := range uploads {
+		seen[*u.UploadId] = true
+	}
+	if len(uploads) != 2500 || len(seen) != 2500 {
+		t.Errorf("listed %d uploads, %d distinct, want 2500", len(uploads), len(seen))
+	}
+	want := []string{
+		"ListMultipartUploads prefix=" + testRepositories + " marker=/",
+		"ListMultipartUploads prefix=" + testRepositories + " marker=" + key + "/id00999",
+		"ListMultipartUploads prefix=" + testRepositories + " marker=" + key + "/id01999",
+	}
+	if got := f.callsOf("ListMultipartUploads"); !slices.Equal(got, want) {
+		t.Errorf("calls %q, want %q", got, want)
+	}
+}
+
+// stuckMarkers is a fakeS3 whose multipart upload listing is truncated
+// without advancing its markers.
+type stuckMarkers struct {
+	*fakeS3
+}
+
+func (s stuckMarkers) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	out, err := s.fakeS3.ListMultipartUploads(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.IsTruncated = aws.Bool(true)
+	out.NextKeyMarker, out.NextUploadIdMarker = params.KeyMarker, params.UploadIdMarker
+	return out, nil
+}
+
+func TestListMultipartUploadsStuckMarkers(t *testing.T) {
+	f := newFakeS3()
+	f.putMultipartUpload(testRepositories+"repo/_uploads/u/data", "id", time.Now())
+
+	cl, _ := newTestCleaner(t, f, Config{})
+	cl.client = stuckMarkers{f}
+	_, err := cl.listMultipartUploads(context.Background(), "bucket", testRepositories)
+	if err == nil || !strings.Contains(err.Error(), "did not advance") {
+		t.Errorf("listing with stuck markers returned %v, want the markers not advancing", err)
+	}
+	if n := len(f.callsOf("ListMultipartUploads")); n != 1 {
+		t.Errorf("%d ListMultipartUploads calls, want 1", n)
+	}
+}
+
+func TestParseTimeFromStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    time.Time
+		wantErr bool
+	}{
+		{"RFC3339Nano", "2024-05-01T10:20:30.123456789Z", time.Date(2024, 5, 1, 10, 20, 30, 123456789, time.UTC), false},
+		{"RFC3339", "2024-05-01T10:20:30+02:00", time.Date(2024, 5, 1, 8, 20, 30, 0, time.UTC), false},
+		{"legacy", time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC).Format(startedadDateFormat), time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), false},
+		{"trailing newline", "2024-05-01T10:20:30Z\n", time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC), false},
+		{"garbage", "not a time", time.Time{}, true},
+		{"empty", "", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeFromStream(strings.NewReader(tt.content))
+			if tt.wantErr {
+				if !errors.Is(err, errUnrecognizedStartedAt) {
+					t.Errorf("parseTimeFromStream(%q) returned %v, %v, want errUnrecognizedStartedAt", tt.content, got, err)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("parseTimeFromStream(%q) = %v, %v, want %v", tt.content, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanUploadFoldersRetriesPage(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	// 151 keys, so the first page of 100 ends inside the folder and no
+	// folder is handled before the second page is requested.
+	f.putUpload(testRepositories+"repo/_uploads/a/", old, make([]int, 150)...)
+	f.putUpload(testRepositories+"repo/_uploads/b/", old, 1)
+	f.fail["ListObjectsV2"] = []error{nil, &smithy.GenericAPIError{Code: "SlowDown"}}
+	token := "token=" + f.sortedKeys(testRepositories)[99]
+
+	cl, out := newTestCleaner(t, f, Config{})
+	summary := &runSummary{bucket: "bucket"}
+	if err := cl.cleanUploadFolders(context.Background(), summary, testRepositories); err != nil {
+		t.Fatalf("cleanUploadFolders: %s\n%s", err, out)
+	}
+
+	var pages []string
+	for _, c := range f.callsOf("ListObjectsV2") {
+		if strings.HasPrefix(c, "ListObjectsV2 prefix="+testRepositories+" ") {
+			pages = append(pages, strings.TrimPrefix(c, "ListObjectsV2 prefix="+testRepositories+" "))
+		}
+	}
+	if want := []string{"token=", token, token}; !slices.Equal(pages, want) {
+		t.Errorf("listed pages %q, want %q", pages, want)
+	}
+	if summary.foldersRemoved != 2 || len(summary.errs) != 0 {
+		t.Errorf("removed %d folders with errors %v, want 2 and none\n%s", summary.foldersRemoved, summary.errs, out)
+	}
+	if got := f.keys(); len(got) != 0 {
+		t.Errorf("keys left %v", got)
+	}
+}
+
+// prefixWithoutSlash is a fakeS3 whose object listing drops the trailing
+// slash of the prefix, like a backend matching prefixes loosely.
+type prefixWithoutSlash struct {
+	*fakeS3
+}
+
+func (p prefixWithoutSlash) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	in := *params
+	in.Prefix = aws.String(strings.TrimSuffix(aws.ToString(params.Prefix), "/"))
+	return p.fakeS3.ListObjectsV2(ctx, &in, optFns...)
+}
+
+func TestFolderObjectsSiblingPrefix(t *testing.T) {
+	now := time.Now()
+	folder := testRepositories + "repo/_uploads/abc"
+	f := newFakeS3()
+	f.putUpload(folder+"/", now, 1)
+	f.putUpload(folder+"d/", now, 1)
+	want := []string{folder + "/data0", folder + "/startedat"}
+
+	for _, tt := range []struct {
+		name   string
+		client S3API
+		errs   int
+	}{
+		{"prefix", f, 0},
+		{"loose prefix", prefixWithoutSlash{f}, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, out := newTestCleaner(t, f, Config{})
+			cl.client = tt.client
+			summary := &runSummary{bucket: "bucket"}
+
+			objects, err := cl.folderObjects(context.Background(), summary, folder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, o := range objects {
+				keys = append(keys, o.key)
+			}
+			if !slices.Equal(keys, want) {
+				t.Errorf("objects of %s: %v, want %v", folder, keys, want)
+			}
+			if len(summary.errs) != tt.errs {
+				t.Errorf("%d errors, want %d\n%s", len(summary.errs), tt.errs, out)
+			}
+		})
+	}
+}
+
+func TestRunKeepsSiblingFolder(t *testing.T) {
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/abc/", time.Now().Add(-48*time.Hour), 1)
+	f.putUpload(testRepositories+"repo/_uploads/abcd/", time.Now(), 1)
+
+	cl, out := newTestCleaner(t, f, Config{})
+	report := run(t, cl, out)
+
+	want := []string{testRepositories + "repo/_uploads/abcd/data0", testRepositories + "repo/_uploads/abcd/startedat"}
+	if got := f.keys(); report.FoldersRemoved != 1 || !slices.Equal(got, want) {
+		t.Errorf("removed %d folders, keys left %v, want 1 and %v\n%s", report.FoldersRemoved, got, want, out)
+	}
+}
@@ -0,0 +1,306 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/logging"
+)
+
+// DefaultRequestTimeout is used for a zero Config.RequestTimeout. It bounds
+// a single HTTP request to the S3 endpoint, so a backend that accepts
+// connections but never answers can't stall the run.
+const DefaultRequestTimeout = 60 * time.Second
+
+// DefaultCopyTimeout is used for a zero Config.CopyTimeout. A CopyObject of
+// a large layer answers only once the backend copied it, which easily
+// takes longer than the RequestTimeout.
+const DefaultCopyTimeout = 15 * time.Minute
+
+// Upper bound for connecting to the S3 endpoint, the TLS handshake has its
+// own of 10s in the SDK.
+const connectTimeout = 10 * time.Second
+
+// Region used to sign requests when only --endpoint is given, most S3
+// compatible backends accept any.
+const defaultRegion = "us-west-1"
+
+// Region the location of the buckets is looked up in with AutoRegion and
+// no --region, the us-east-1 endpoint answers for buckets of any region.
+const bootstrapRegion = "us-east-1"
+
+// awsRegion matches AWS region names, as opposed to the placeholders some
+// S3 compatible backends return as the bucket location.
+var awsRegion = regexp.MustCompile(`^[a-z]{2}(?:-gov|-iso[a-z]?)?-[a-z]+-\d+$`)
+
+// awsEndpointRegion matches the region in the host name of regional AWS S3
+// endpoints, e.g. s3.eu-west-1.amazonaws.com or
+// s3-fips.dualstack.us-east-1.amazonaws.com.
+var awsEndpointRegion = regexp.MustCompile(`(?:^|\.)s3(?:-fips)?(?:\.dualstack)?[.-]([a-z]{2}(?:-gov|-iso[a-z]?)?-[a-z]+-\d+)\.amazonaws\.com(?:\.cn)?$`)
+
+// newS3Client creates the S3 client for the endpoint, TLS and credential
+// settings of the Config.
+func (cl *Cleaner) newS3Client(ctx context.Context) (*s3.Client, error) {
+	endPoint := cl.cfg.Endpoint
+	if endPoint != "" && (cl.cfg.UseDualstack || cl.cfg.UseFIPS) {
+		return nil, errors.New("--use-dualstack and --use-fips select an AWS endpoint and can't be combined with --endpoint")
+	}
+	pathStyle := usePathStyle(cl.cfg.AddressingStyle, endPoint)
+	if cl.cfg.SignatureVersion == "v2" && !pathStyle {
+		return nil, errors.New("--signature-version v2 only works with path style addressing")
+	}
+
+	region := cl.cfg.Region
+	if r := endpointRegion(endPoint); r != "" {
+		if region != "" && region != r {
+			return nil, fmt.Errorf("endpoint %s is in region %s, but --region is %s", endPoint, r, region)
+		}
+		region = r
+	}
+	if region == "" && endPoint == "" {
+		region = bootstrapRegion
+	} else if region == "" {
+		region = defaultRegion
+	}
+
+	configOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+	}
+
+	if cl.cfg.UseDualstack {
+		configOptions = append(configOptions, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if cl.cfg.UseFIPS {
+		configOptions = append(configOptions, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+
+	if cl.cfg.AccessKey != "" {
+		configOptions = append(configOptions, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cl.cfg.AccessKey, cl.cfg.SecretKey, ""),
+		))
+	}
+
+	tlsConf, err := cl.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// A client of its own, so the timeouts don't depend on (or change)
+	// http.DefaultTransport. Idle connections are kept for the concurrent
+	// deletes plus the listing next to them.
+	cl.proxy, err = cl.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	maxIdle := cl.cfg.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = cl.cfg.Concurrency + 2
+	}
+	httpClient := awshttp.NewBuildableClient().
+		WithTimeout(cl.cfg.RequestTimeout).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = connectTimeout
+		}).
+		WithTransportOptions(func(tr *http.Transport) {
+			tr.MaxIdleConnsPerHost = maxIdle
+			tr.Proxy = cl.proxy
+			if tlsConf != nil {
+				tr.TLSClientConfig = tlsConf
+			}
+		})
+	configOptions = append(configOptions, config.WithHTTPClient(httpClient))
+	cl.copyHTTPClient = httpClient.WithTimeout(cl.cfg.CopyTimeout)
+
+	if cl.cfg.Debug || cl.cfg.DebugHTTPBody {
+		mode := aws.LogRequest | aws.LogResponse | aws.LogRetries
+		if cl.cfg.DebugHTTPBody {
+			mode = aws.LogRequestWithBody | aws.LogResponseWithBody | aws.LogRetries
+		}
+		configOptions = append(configOptions,
+			config.WithClientLogMode(mode),
+			config.WithLogger(logging.NewStandardLogger(os.Stderr)))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(ctx, configOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without static keys, resolve the credential chain now so missing
+	// credentials are reported before any listing, and the provider that
+	// was picked (e.g. the IRSA web identity token) shows in the banner.
+	if cl.cfg.AccessKey == "" {
+		creds, err := awsConfig.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("no credentials found in the AWS credential chain: %w", err)
+		}
+		cl.cfg.CredentialSource = "AWS credential chain, " + describeCredentialSource(creds.Source)
+	}
+
+	return s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+		if endPoint != "" {
+			o.BaseEndpoint = aws.String(endpointURL(endPoint))
+		}
+
+		// Only send and verify checksums where the API requires them, most
+		// S3 compatible backends don't support the newer checksum headers.
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+
+		if cl.cfg.SignatureVersion == "v2" {
+			useSignatureV2(o)
+		}
+		o.APIOptions = append(o.APIOptions, cl.apiStats.countRetries)
+	}), nil
+}
+
+// copyOptions returns the options of the CopyObject requests, which are
+// bounded by the CopyTimeout instead of the RequestTimeout.
+func (cl *Cleaner) copyOptions() []func(*s3.Options) {
+	if cl.copyHTTPClient == nil {
+		return nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) {
+		o.HTTPClient = cl.copyHTTPClient
+	}}
+}
+
+// bucketRegion looks up the region of the buckets with GetBucketLocation.
+// On custom endpoints, a location that isn't an AWS region (Ceph returns
+// its zone group, e.g. "default") or a failed lookup falls back to
+// --region, or the default region. A location the buckets don't agree on
+// is an error, the run uses one client for all of them.
+func (cl *Cleaner) bucketRegion(ctx context.Context, s *s3.Client) (string, error) {
+	fallback := cl.cfg.Region
+	if fallback == "" {
+		fallback = defaultRegion
+	}
+
+	var region, first string
+	for _, bucket := range bucketNames(cl.cfg.Buckets) {
+		resp, err := s.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+		cl.apiStats.call("GetBucketLocation", err)
+
+		var location string
+		switch {
+		case err != nil && cl.cfg.Endpoint != "":
+			cl.printf("WARNING: can't get the location of bucket %s, using region %s: %s\n", bucket, fallback, err)
+			location = fallback
+		case err != nil:
+			return "", fmt.Errorf("detecting the region of bucket %s (give --region instead): %w", bucket, err)
+		case resp.LocationConstraint == "":
+			location = "us-east-1"
+		case resp.LocationConstraint == "EU":
+			location = "eu-west-1"
+		case cl.cfg.Endpoint != "" && !awsRegion.MatchString(string(resp.LocationConstraint)):
+			cl.printf("WARNING: bucket %s has location %q, which isn't a region; using %s\n", bucket, resp.LocationConstraint, fallback)
+			location = fallback
+		default:
+			location = string(resp.LocationConstraint)
+		}
+
+		if region == "" {
+			region, first = location, bucket
+		} else if location != region {
+			return "", fmt.Errorf("bucket %s is in region %s, but bucket %s in %s; clean them in separate runs", first, region, bucket, location)
+		}
+	}
+	return region, nil
+}
+
+// resolvedEndpoint returns the endpoint requests are sent to, the one given
+// with --endpoint or the AWS endpoint resolved for the region.
+func resolvedEndpoint(ctx context.Context, s *s3.Client) (string, error) {
+	o := s.Options()
+	endpoint, err := o.EndpointResolverV2.ResolveEndpoint(ctx, s3.EndpointParameters{
+		Region:         aws.String(o.Region),
+		UseFIPS:        aws.Bool(o.EndpointOptions.UseFIPSEndpoint == aws.FIPSEndpointStateEnabled),
+		UseDualStack:   aws.Bool(o.EndpointOptions.UseDualStackEndpoint == aws.DualStackEndpointStateEnabled),
+		ForcePathStyle: aws.Bool(o.UsePathStyle),
+		Endpoint:       o.BaseEndpoint,
+	})
+
+	if err != nil {
+		return "", err
+	}
+	return endpoint.URI.String(), nil
+}
+
+// usePathStyle reports whether buckets are addressed in the path rather than
+// the host name, following style (--addressing-style). Custom endpoints like MinIO
+// and Ceph usually only support path style, without --endpoint it's AWS.
+func usePathStyle(style, endPoint string) bool {
+	switch style {
+	case "path":
+		return true
+	case "virtual":
+		return false
+	case "auto":
+		if endPoint == "" {
+			return false
+		}
+		u, err := url.Parse(endpointURL(endPoint))
+		if err != nil {
+			return true
+		}
+		return !strings.HasSuffix(u.Hostname(), ".amazonaws.com")
+	}
+	return endPoint != ""
+}
+
+// endpointRegion returns the region of a regional AWS S3 endpoint, "" for
+// other endpoints.
+func endpointRegion(endPoint string) string {
+	if endPoint == "" {
+		return ""
+	}
+	u, err := url.Parse(endpointURL(endPoint))
+	if err != nil {
+		return ""
+	}
+	if m := awsEndpointRegion.FindStringSubmatch(u.Hostname()); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// endpointURL defaults endpoints given without a scheme to plain HTTP.
+func endpointURL(endPoint string) string {
+	if strings.Contains(endPoint, "://") {
+		return endPoint
+	}
+	return "http://" + endPoint
+}
+
+// describeCredentialSource returns a readable name for the Source of
+// credentials resolved from the AWS credential chain.
+func describeCredentialSource(source string) string {
+	switch {
+	case source == stscreds.WebIdentityProviderName:
+		return "web identity token (" + os.Getenv("AWS_ROLE_ARN") + ")"
+	case source == ec2rolecreds.ProviderName:
+		return "EC2 instance role"
+	case source == config.CredentialsSourceName:
+		return "environment (AWS_ACCESS_KEY_ID)"
+	case strings.HasPrefix(source, "SharedConfigCredentials"):
+		return "shared credentials file"
+	}
+	return source
+}
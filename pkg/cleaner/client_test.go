@@ -0,0 +1,176 @@
+package cleaner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestUsePathStyle(t *testing.T) {
+	tests := []struct {
+		style, endpoint string
+		want            bool
+	}{
+		{"", "", false},
+		{"", "https://s3.eu-west-1.amazonaws.com", true},
+		{"", "10.0.0.5:9000", true},
+		{"", "https://minio.example.com", true},
+		{"auto", "", false},
+		{"auto", "https://s3.eu-west-1.amazonaws.com", false},
+		{"auto", "s3.us-east-1.amazonaws.com", false},
+		{"auto", "10.0.0.5:9000", true},
+		{"auto", "https://minio.example.com", true},
+		{"path", "https://s3.eu-west-1.amazonaws.com", true},
+		{"virtual", "https://minio.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := usePathStyle(tt.style, tt.endpoint); got != tt.want {
+			t.Errorf("usePathStyle(%q, %q) = %t, want %t", tt.style, tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointRegion(t *testing.T) {
+	tests := []struct {
+		endpoint, want string
+	}{
+		{"", ""},
+		{"https://s3.eu-west-1.amazonaws.com", "eu-west-1"},
+		{"s3-eu-west-1.amazonaws.com", "eu-west-1"},
+		{"https://s3-fips.dualstack.us-east-1.amazonaws.com", "us-east-1"},
+		{"https://registry.s3.us-gov-west-1.amazonaws.com", "us-gov-west-1"},
+		{"https://s3.cn-north-1.amazonaws.com.cn", "cn-north-1"},
+		{"https://s3.amazonaws.com", ""},
+		{"10.0.0.5:9000", ""},
+		{"https://minio.example.com", ""},
+		{"https://s3.eu-west-1.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		if got := endpointRegion(tt.endpoint); got != tt.want {
+			t.Errorf("endpointRegion(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestResolvedEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		endpoint string
+		options  func(*s3.Options)
+		want     string
+	}{
+		{"AWS", "eu-west-1", "", nil, "https://s3.eu-west-1.amazonaws.com"},
+		{"AWS dual-stack", "eu-west-1", "", func(o *s3.Options) {
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+		}, "https://s3.dualstack.eu-west-1.amazonaws.com"},
+		{"AWS FIPS", "us-east-1", "", func(o *s3.Options) {
+			o.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		}, "https://s3-fips.us-east-1.amazonaws.com"},
+		{"MinIO", "us-west-1", "http://10.0.0.5:9000", nil, "http://10.0.0.5:9000"},
+		{"custom DNS name", "us-west-1", "https://s3.storage.example.com", nil, "https://s3.storage.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := s3.Options{Region: tt.region, UsePathStyle: usePathStyle("", tt.endpoint)}
+			if tt.endpoint != "" {
+				o.BaseEndpoint = aws.String(tt.endpoint)
+			}
+			if tt.options != nil {
+				tt.options(&o)
+			}
+
+			got, err := resolvedEndpoint(context.Background(), s3.New(o))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolved %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// urlRecorder is an HTTP client recording the URL of every request and
+// answering it with an empty listing.
+type urlRecorder struct {
+	urls []*url.URL
+}
+
+func (r *urlRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.urls = append(r.urls, req.URL)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/xml"}},
+		Body:       io.NopCloser(strings.NewReader(`<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated></ListBucketResult>`)),
+		Request:    req,
+	}, nil
+}
+
+func TestRequestURL(t *testing.T) {
+	tests := []struct {
+		style    string
+		endpoint string
+		bucket   string
+		host     string
+		path     string
+	}{
+		{"", "", "bucket", "bucket.s3.eu-west-1.amazonaws.com", "/"},
+		{"path", "", "bucket", "s3.eu-west-1.amazonaws.com", "/bucket"},
+		{"virtual", "", "bucket", "bucket.s3.eu-west-1.amazonaws.com", "/"},
+		{"auto", "", "bucket", "bucket.s3.eu-west-1.amazonaws.com", "/"},
+		{"", "https://s3.eu-west-1.amazonaws.com", "bucket", "s3.eu-west-1.amazonaws.com", "/bucket"},
+		{"auto", "https://s3.eu-west-1.amazonaws.com", "bucket", "bucket.s3.eu-west-1.amazonaws.com", "/"},
+		{"", "https://minio.example.com", "bucket", "minio.example.com", "/bucket"},
+		{"path", "https://minio.example.com", "bucket", "minio.example.com", "/bucket"},
+		{"virtual", "https://minio.example.com", "bucket", "bucket.minio.example.com", "/"},
+		{"auto", "https://minio.example.com", "bucket", "minio.example.com", "/bucket"},
+		{"auto", "10.0.0.5:9000", "bucket", "10.0.0.5:9000", "/bucket"},
+		// Names that aren't valid host names are always addressed in the
+		// path.
+		{"virtual", "", "b", "s3.eu-west-1.amazonaws.com", "/b"},
+		{"virtual", "https://minio.example.com", "my_bucket", "minio.example.com", "/my_bucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style+" "+tt.endpoint+" "+tt.bucket, func(t *testing.T) {
+			cl, err := New(Config{
+				Endpoint:         tt.endpoint,
+				Region:           "eu-west-1",
+				AddressingStyle:  tt.style,
+				AccessKey:        "AKIDEXAMPLE",
+				SecretKey:        "secret",
+				Buckets:          []string{tt.bucket},
+				CleanupThreshold: 12 * time.Hour,
+				Output:           io.Discard,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &urlRecorder{}
+			_, err = cl.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String(tt.bucket)}, func(o *s3.Options) {
+				o.HTTPClient = recorder
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recorder.urls) != 1 {
+				t.Fatalf("%d requests, want 1", len(recorder.urls))
+			}
+			if u := recorder.urls[0]; u.Host != tt.host || u.Path != tt.path {
+				t.Errorf("request to host %s path %s, want host %s path %s", u.Host, u.Path, tt.host, tt.path)
+			}
+		})
+	}
+}
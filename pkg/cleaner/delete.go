@@ -0,0 +1,291 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// DeleteObjects accepts at most this many keys per request.
+const deleteBatchSize = 1000
+
+// Attempts made for keys failing with a transient error code.
+const deleteAttempts = 3
+
+// DefaultConcurrency is used for a zero Config.Concurrency.
+const DefaultConcurrency = 4
+
+// Per-key DeleteObjects error codes that will fail the same way on every
+// attempt, typically because the backend doesn't accept the key name.
+var permanentDeleteErrors = map[string]bool{
+	"InvalidKeyName":  true,
+	"KeyTooLong":      true,
+	"KeyTooLongError": true,
+}
+
+// Per-key DeleteObjects error codes worth retrying.
+var transientDeleteErrors = map[string]bool{
+	"InternalError":      true,
+	"OperationAborted":   true,
+	"RequestTimeout":     true,
+	"ServiceUnavailable": true,
+	"SlowDown":           true,
+}
+
+// undeletableKey is a key the backend refused to delete for good. These
+// have to be removed by hand, e.g. from the provider's console.
+type undeletableKey struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+// objectVersion identifies an object to delete, or one version of it in a
+// versioned bucket. current is set for the version holding the object's
+// current content.
+type objectVersion struct {
+	key       string
+	versionID string
+	size      int64
+	current   bool
+}
+
+// placeholder reports whether o is a zero-byte directory placeholder, as
+// Ceph RGW and some S3 clients create for folders.
+func (o objectVersion) placeholder() bool {
+	return o.size == 0 && strings.HasSuffix(o.key, "/")
+}
+
+func (o objectVersion) id() string {
+	return o.key + "\x00" + o.versionID
+}
+
+// auditEntry returns the audit log entry for deleting o, without the
+// outcome.
+func (o objectVersion) auditEntry(bucket string) auditEntry {
+	size := o.size
+	return auditEntry{
+		Time:      time.Now().UTC(),
+		Action:    "delete_object",
+		Bucket:    bucket,
+		Key:       o.key,
+		VersionID: o.versionID,
+		Size:      &size,
+	}
+}
+
+func (o objectVersion) String() string {
+	if o.versionID == "" {
+		return o.key
+	}
+	return fmt.Sprintf("%s (version %s)", o.key, o.versionID)
+}
+
+// deleteKeys removes objects with batched DeleteObjects calls, up to
+// Concurrency of them at a time, adding the bytes removed to the summary.
+// Objects failing with a transient error are retried, objects failing with
+// a permanent error are recorded as undeletable after the first attempt.
+// It returns the number of objects left, which includes the objects of
+// failed requests and those not attempted once the run is stopped, and the
+// errors of the failed requests. The cause of the stop isn't one of them,
+// it is recorded where the run is stopped.
+func (cl *Cleaner) deleteKeys(ctx context.Context, summary *runSummary, objects []objectVersion) (left int, err error) {
+	pending := objects
+	var requestErrs []error
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		var (
+			mu    sync.Mutex
+			wg    sync.WaitGroup
+			retry []objectVersion
+		)
+		workers := make(chan struct{}, cl.cfg.Concurrency)
+
+		for start := 0; start < len(pending); start += deleteBatchSize {
+			end := start + deleteBatchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			if ctx.Err() != nil {
+				mu.Lock()
+				left += len(pending) - start
+				mu.Unlock()
+				break
+			}
+
+			workers <- struct{}{}
+			wg.Add(1)
+			go func(batch []objectVersion) {
+				defer func() {
+					<-workers
+					wg.Done()
+				}()
+
+				failed, retryBatch, err := cl.deleteBatch(ctx, summary, &mu, batch, attempt)
+
+				mu.Lock()
+				defer mu.Unlock()
+				left += failed
+				retry = append(retry, retryBatch...)
+				if err != nil {
+					requestErrs = append(requestErrs, err)
+				}
+			}(pending[start:end])
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return left + len(retry), errors.Join(requestErrs...)
+		}
+
+		if len(retry) > 0 {
+			cl.printf("    Retrying %d keys (attempt %d of %d)\n", len(retry), attempt+1, deleteAttempts)
+			select {
+			case <-ctx.Done():
+				return left + len(retry), errors.Join(requestErrs...)
+			case <-time.After(time.Duration(attempt) * retryPause):
+			}
+		}
+		pending = retry
+	}
+
+	return left, errors.Join(requestErrs...)
+}
+
+// deleteBatch makes one DeleteObjects request for batch. It returns the
+// number of objects that failed for good, the objects to retry and the
+// error of the request, when all of it failed. mu guards the summary.
+func (cl *Cleaner) deleteBatch(ctx context.Context, summary *runSummary, mu *sync.Mutex, objects []objectVersion, attempt int) (failed int, retry []objectVersion, err error) {
+	batch := map[string]objectVersion{}
+	byKey := map[string][]objectVersion{}
+	identifiers := make([]types.ObjectIdentifier, 0, len(objects))
+	for _, o := range objects {
+		batch[o.id()] = o
+		byKey[o.key] = append(byKey[o.key], o)
+		identifier := types.ObjectIdentifier{Key: aws.String(o.key)}
+		if o.versionID != "" {
+			identifier.VersionId = aws.String(o.versionID)
+		}
+		identifiers = append(identifiers, identifier)
+	}
+
+	// The run is stopped when the audit log can't be written, the objects
+	// are left like those not attempted.
+	if !cl.auditReady() {
+		return len(objects), nil, nil
+	}
+
+	resp, err := cl.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(summary.bucket),
+		Delete: &types.Delete{Objects: identifiers},
+	})
+
+	if err != nil {
+		entries := make([]auditEntry, 0, len(objects))
+		for _, o := range objects {
+			entries = append(entries, auditOutcome(o.auditEntry(summary.bucket), err))
+		}
+		cl.audit(entries...)
+		return len(objects), nil, err
+	}
+
+	// Keys failing inside a successful response don't carry the request
+	// ID the way failed requests do, it is added so the backend's operator
+	// can look them up.
+	requestID, _ := awsmiddleware.GetRequestIDMetadata(resp.ResultMetadata)
+
+	entries := make([]auditEntry, 0, len(objects))
+
+	// The entries of the response are matched to the objects of the batch
+	// by key and version ID, or by key alone when only one version of it
+	// is in the batch: some backends, e.g. MinIO, return a version ID for
+	// deletes in unversioned buckets. An entry matching no object is an
+	// error, the object it was meant for is left.
+	answered := map[string]bool{}
+	match := func(key, versionID *string) (objectVersion, bool) {
+		if key == nil {
+			return objectVersion{}, false
+		}
+		o, ok := batch[objectVersion{key: *key, versionID: aws.ToString(versionID)}.id()]
+		if !ok && len(byKey[*key]) == 1 {
+			o, ok = byKey[*key][0], true
+		}
+		if !ok || answered[o.id()] {
+			return objectVersion{}, false
+		}
+		answered[o.id()] = true
+		return o, true
+	}
+	unmatched := func(key, versionID *string) {
+		entry := "an entry without a key"
+		if key != nil {
+			entry = objectVersion{key: *key, versionID: aws.ToString(versionID)}.String() + ", which wasn't requested or was answered already"
+		}
+		err := fmt.Errorf("removing objects (RequestID: %s): the response holds %s", requestID, entry)
+		cl.printf("    ERROR: %s\n", err)
+		mu.Lock()
+		cl.addError(summary, err)
+		mu.Unlock()
+	}
+
+	for _, d := range resp.Deleted {
+		o, ok := match(d.Key, d.VersionId)
+		if !ok {
+			unmatched(d.Key, d.VersionId)
+			continue
+		}
+		mu.Lock()
+		if o.placeholder() {
+			cl.printf("    Removing placeholder %s\n", o)
+			summary.placeholdersRemoved++
+		} else {
+			cl.printf("    Removing %s\n", o)
+			cl.progress.objectsDeleted.Add(1)
+			summary.bytesReclaimed += o.size
+		}
+		mu.Unlock()
+		entries = append(entries, auditOutcome(o.auditEntry(summary.bucket), nil))
+	}
+
+	for _, e := range resp.Errors {
+		o, ok := match(e.Key, e.VersionId)
+		if !ok {
+			unmatched(e.Key, e.VersionId)
+			continue
+		}
+		code, message := aws.ToString(e.Code), aws.ToString(e.Message)
+		entries = append(entries, auditOutcome(o.auditEntry(summary.bucket), &smithy.GenericAPIError{Code: code, Message: message}))
+
+		switch {
+		case permanentDeleteErrors[code]:
+			cl.printf("    Undeletable %s (%s: %s, RequestID: %s)\n", o, code, message, requestID)
+			mu.Lock()
+			summary.undeletable = append(summary.undeletable, undeletableKey{Key: o.key, Code: code, Message: message})
+			mu.Unlock()
+			failed++
+		case transientDeleteErrors[code] && attempt < deleteAttempts:
+			retry = append(retry, o)
+		default:
+			err := fmt.Errorf("removing %s (RequestID: %s): %w", o, requestID, &smithy.GenericAPIError{Code: code, Message: message})
+			cl.printf("    ERROR: %s\n", err)
+			mu.Lock()
+			cl.addError(summary, err)
+			mu.Unlock()
+			failed++
+		}
+	}
+
+	failed += len(objects) - len(answered)
+
+	cl.audit(entries...)
+	return failed, retry, nil
+}
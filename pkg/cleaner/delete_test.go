@@ -0,0 +1,180 @@
+package cleaner
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// mixedDeleteErrors seeds f with keys failing in different ways in a
+// DeleteObjects response and returns them.
+func mixedDeleteErrors(f *fakeS3) []objectVersion {
+	folder := testRepositories + "repo/_uploads/u/"
+	codes := map[string][]string{
+		"ok":      nil,
+		"invalid": {"InvalidKeyName"},
+		"long":    {"KeyTooLong"},
+		"slow":    {"SlowDown", ""},
+		"stuck":   {"SlowDown", "SlowDown", "SlowDown"},
+	}
+
+	var objects []objectVersion
+	for _, name := range []string{"invalid", "long", "ok", "slow", "stuck"} {
+		key := folder + name
+		f.put(key, []byte("x"), time.Now())
+		f.deleteErrs[key] = codes[name]
+		objects = append(objects, objectVersion{key: key, size: 1, current: true})
+	}
+	return objects
+}
+
+func TestDeleteBatchMixedErrors(t *testing.T) {
+	f := newFakeS3()
+	objects := mixedDeleteErrors(f)
+
+	cl, out := newTestCleaner(t, f, Config{})
+	summary := &runSummary{bucket: "bucket"}
+	failed, retry, err := cl.deleteBatch(context.Background(), summary, &sync.Mutex{}, objects, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed != 2 {
+		t.Errorf("%d keys failed for good, want 2\n%s", failed, out)
+	}
+	var retried []string
+	for _, o := range retry {
+		retried = append(retried, o.key[strings.LastIndex(o.key, "/")+1:])
+	}
+	if !slices.Equal(retried, []string{"slow", "stuck"}) {
+		t.Errorf("retrying %v, want [slow stuck]", retried)
+	}
+	var undeletable []string
+	for _, u := range summary.undeletable {
+		undeletable = append(undeletable, u.Code)
+	}
+	if !slices.Equal(undeletable, []string{"InvalidKeyName", "KeyTooLong"}) {
+		t.Errorf("undeletable %v, want InvalidKeyName and KeyTooLong", undeletable)
+	}
+	if len(summary.errs) != 0 || summary.bytesReclaimed != 1 {
+		t.Errorf("errors %v and %d bytes reclaimed, want none and 1", summary.errs, summary.bytesReclaimed)
+	}
+}
+
+func TestDeleteKeysRetriesTransientErrors(t *testing.T) {
+	f := newFakeS3()
+	objects := mixedDeleteErrors(f)
+
+	cl, out := newTestCleaner(t, f, Config{})
+	summary := &runSummary{bucket: "bucket"}
+	left, err := cl.deleteKeys(context.Background(), summary, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid and long are undeletable, stuck gave up after every attempt.
+	if left != 3 || len(summary.undeletable) != 2 || len(summary.errs) != 1 {
+		t.Errorf("%d keys left, %d undeletable, errors %v, want 3, 2 and the one of stuck\n%s",
+			left, len(summary.undeletable), summary.errs, out)
+	}
+	calls := f.callsOf("DeleteObjects")
+	if len(calls) != deleteAttempts {
+		t.Fatalf("%d DeleteObjects calls, want %d: %q", len(calls), deleteAttempts, calls)
+	}
+	for _, c := range calls[1:] {
+		if strings.Contains(c, "/invalid") || strings.Contains(c, "/long") {
+			t.Errorf("permanently failing key retried: %s", c)
+		}
+	}
+	if !strings.HasSuffix(calls[2], "/stuck") {
+		t.Errorf("last attempt %s, want only stuck", calls[2])
+	}
+
+	want := []string{testRepositories + "repo/_uploads/u/invalid", testRepositories + "repo/_uploads/u/long", testRepositories + "repo/_uploads/u/stuck"}
+	if got := f.keys(); !slices.Equal(got, want) {
+		t.Errorf("keys left %v, want %v", got, want)
+	}
+}
+
+// rewrittenDeletes is a fakeS3 whose DeleteObjects responses are passed
+// through rewrite, like those of backends not echoing the request.
+type rewrittenDeletes struct {
+	*fakeS3
+	rewrite func(*s3.DeleteObjectsOutput)
+}
+
+func (r rewrittenDeletes) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out, err := r.fakeS3.DeleteObjects(ctx, params, optFns...)
+	if err == nil {
+		r.rewrite(out)
+	}
+	return out, err
+}
+
+func TestDeleteBatchUnmatchedEntries(t *testing.T) {
+	folder := testRepositories + "repo/_uploads/u/"
+	tests := []struct {
+		name    string
+		rewrite func(*s3.DeleteObjectsOutput)
+		failed  int
+		errs    int
+	}{
+		{"version ID of an unversioned delete", func(out *s3.DeleteObjectsOutput) {
+			for i := range out.Deleted {
+				out.Deleted[i].VersionId = aws.String("minio-version")
+			}
+			for i := range out.Errors {
+				out.Errors[i].VersionId = aws.String("minio-version")
+			}
+		}, 1, 0},
+		{"unknown key", func(out *s3.DeleteObjectsOutput) {
+			out.Deleted[0].Key = aws.String(folder + "other")
+		}, 2, 1},
+		{"no key", func(out *s3.DeleteObjectsOutput) {
+			out.Deleted[0].Key = nil
+			out.Errors[0].Key = nil
+		}, 2, 2},
+		{"key answered twice", func(out *s3.DeleteObjectsOutput) {
+			out.Deleted = append(out.Deleted, out.Deleted[0])
+		}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			var objects []objectVersion
+			for _, name := range []string{"data", "invalid"} {
+				f.put(folder+name, []byte("x"), time.Now())
+				objects = append(objects, objectVersion{key: folder + name, size: 1, current: true})
+			}
+			f.deleteErrs[folder+"invalid"] = []string{"InvalidKeyName"}
+
+			cl, out := newTestCleaner(t, f, Config{})
+			cl.client = rewrittenDeletes{fakeS3: f, rewrite: tt.rewrite}
+			summary := &runSummary{bucket: "bucket"}
+			failed, retry, err := cl.deleteBatch(context.Background(), summary, &sync.Mutex{}, objects, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if failed != tt.failed || len(retry) != 0 || len(summary.errs) != tt.errs {
+				t.Errorf("%d failed, %d to retry, errors %v, want %d, none and %d errors\n%s",
+					failed, len(retry), summary.errs, tt.failed, tt.errs, out)
+			}
+			for _, u := range summary.undeletable {
+				if u.Key != folder+"invalid" {
+					t.Errorf("undeletable key %q, want only %s", u.Key, folder+"invalid")
+				}
+			}
+			if strings.Contains(out.String(), "Removing \n") || summary.bytesReclaimed > 1 {
+				t.Errorf("removal of an unmatched entry reported, %d bytes reclaimed\n%s", summary.bytesReclaimed, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// errUnrecognizedStartedAt is wrapped by errors about startedat files
+// whose content isn't a known timestamp format.
+var errUnrecognizedStartedAt = errors.New("unrecognized startedat timestamp")
+
+// errMalformedAbortList is wrapped by errors about lines of the AbortList
+// that are neither a key and upload ID nor a comment.
+var errMalformedAbortList = errors.New("malformed abort list line")
+
+// errGaveUp is wrapped by errors of requests that kept failing with a
+// transient error until the attempts ran out.
+var errGaveUp = errors.New("giving up")
+
+// errFailFast is the cause of the cancellation of a run stopped by its
+// first error with FailFast.
+var errFailFast = errors.New("stopped at the first error (--fail-fast)")
+
+// listingError is returned by cleanBucket when the listing of the bucket it
+// starts with fails, before anything was cleaned.
+type listingError struct {
+	err error
+}
+
+func (e *listingError) Error() string { return e.err.Error() }
+
+func (e *listingError) Unwrap() error { return e.err }
+
+// Error types that fail the same way for every bucket: the credentials are
+// wrong or the endpoint can't be reached.
+var globalErrorTypes = map[string]bool{
+	"InvalidAccessKeyId":    true,
+	"SignatureDoesNotMatch": true,
+	"ExpiredToken":          true,
+	"InvalidToken":          true,
+	"NetworkError":          true,
+	"ProxyError":            true,
+}
+
+// isGlobalError reports whether err would fail the other buckets of the run
+// too, so there is no point in going on.
+func isGlobalError(err error) bool {
+	return globalErrorTypes[errorType(err)]
+}
+
+// errorType classifies err for the error summary, by the S3 error code
+// where there is one.
+func errorType(err error) string {
+	var apiErr smithy.APIError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, errGaveUp):
+		return "TransientGaveUp"
+	case errors.As(err, &apiErr):
+		return apiErr.ErrorCode()
+	case errors.Is(err, errUnrecognizedStartedAt):
+		return "UnrecognizedStartedAt"
+	case errors.Is(err, errMalformedAbortList):
+		return "MalformedAbortList"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timeout"
+	case isProxyError(err):
+		return "ProxyError"
+	case errors.As(err, &netErr):
+		return "NetworkError"
+	}
+	return "Other"
+}
+
+// isNotFound reports whether err means the object doesn't exist, as
+// opposed to e.g. its bucket.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var apiErr smithy.APIError
+	var respErr *awshttp.ResponseError
+
+	switch {
+	case errors.As(err, &noSuchKey):
+		return true
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() != "NotFound":
+		return false
+	}
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// isTransient reports whether a request failing with err may succeed when
+// it is repeated later: throttling, server errors and network errors.
+func isTransient(err error) bool {
+	var apiErr smithy.APIError
+	var respErr *awshttp.ResponseError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &apiErr) && (transientDeleteErrors[apiErr.ErrorCode()] || apiErr.ErrorCode() == "Throttling"):
+		return true
+	case errors.As(err, &respErr):
+		return respErr.HTTPStatusCode() >= 500 || respErr.HTTPStatusCode() == http.StatusTooManyRequests
+	}
+	return errors.As(err, &netErr)
+}
+
+// retryPause is the base of the pauses between attempts of failed
+// requests, they grow with every attempt.
+var retryPause = time.Second
+
+// retryTransient calls fn until it succeeds, fails with an error that isn't
+// transient or has failed attempts times, pausing a little longer after
+// every failure.
+func retryTransient[T any](ctx context.Context, attempts int, fn func() (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		v, err := fn()
+		if err == nil || !isTransient(err) {
+			return v, err
+		}
+		if attempt == attempts {
+			return v, fmt.Errorf("%w after %d attempts: %w", errGaveUp, attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case <-time.After(time.Duration(attempt) * 2 * retryPause):
+		}
+	}
+}
+
+// addError records err in the summary. With FailFast an error of an S3
+// request also stops the run, so no further upload is aborted and no
+// further object deleted; errors about the content of a startedat file or
+// the abort list only skip the entry.
+func (cl *Cleaner) addError(summary *runSummary, err error) {
+	summary.errs = append(summary.errs, err)
+	if cl.cfg.FailFast && isRequestError(err) {
+		cl.halt(fmt.Errorf("%w: %w", errFailFast, err))
+	}
+}
+
+// isRequestError reports whether err comes from a request to the endpoint,
+// a failed operation or a per-key error of a DeleteObjects response.
+func isRequestError(err error) bool {
+	var opErr *smithy.OperationError
+	var apiErr smithy.APIError
+	return errors.As(err, &opErr) || errors.As(err, &apiErr)
+}
+
+// printErrorSummary prints the number of errors of the run by type, and
+// returns the total.
+func (cl *Cleaner) printErrorSummary(summaries []*runSummary) int {
+	total := 0
+	types := map[string]int{}
+	for _, r := range summaries {
+		for _, err := range r.errs {
+			total++
+			types[errorType(err)]++
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if types[names[i]] != types[names[j]] {
+			return types[names[i]] > types[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	cl.println()
+	cl.printf("Errors: %d\n", total)
+	for _, name := range names {
+		cl.printf("  %s: %d\n", name, types[name])
+	}
+	return total
+}
@@ -0,0 +1,494 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeVersion is one version of an object in a fakeS3 bucket, or a delete
+// marker.
+type fakeVersion struct {
+	id           string
+	body         []byte
+	modified     time.Time
+	deleteMarker bool
+}
+
+// fakeS3 is an in-memory S3API with a single bucket. Listings are paginated
+// like S3 does, at most pageSize entries per page (1000 when zero), so
+// pagination is exercised with few objects. Object versions are only kept
+// when versioned is set.
+type fakeS3 struct {
+	mu sync.Mutex
+
+	versioned bool
+	pageSize  int
+
+	// objects holds the versions of every key, the current one last.
+	objects map[string][]fakeVersion
+	uploads []types.MultipartUpload
+
+	// fail injects errors: every call of an operation takes the next
+	// entry of its list, nil meaning the call goes through.
+	fail map[string][]error
+
+	// deleteErrs makes DeleteObjects report a per-key error code for a
+	// key, one entry per attempt; "" lets the attempt succeed.
+	deleteErrs map[string][]string
+
+	// calls logs every request as "Operation argument", e.g.
+	// "ListObjectsV2 token=..." or "DeleteObjects key".
+	calls   []string
+	version int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]fakeVersion{}, fail: map[string][]error{}, deleteErrs: map[string][]string{}}
+}
+
+// put stores an object, a new version of it in a versioned bucket.
+func (f *fakeS3) put(key string, body []byte, modified time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.putLocked(key, fakeVersion{body: body, modified: modified})
+}
+
+func (f *fakeS3) putLocked(key string, v fakeVersion) {
+	if !f.versioned {
+		v.id = "null"
+		f.objects[key] = []fakeVersion{v}
+		return
+	}
+	f.version++
+	v.id = fmt.Sprintf("v%06d", f.version)
+	f.objects[key] = append(f.objects[key], v)
+}
+
+// putUpload adds an upload folder with a startedat file written at started,
+// and data objects of the given sizes.
+func (f *fakeS3) putUpload(folder string, started time.Time, sizes ...int) {
+	f.put(folder+"startedat", []byte(started.UTC().Format(time.RFC3339)), started)
+	for i, size := range sizes {
+		f.put(fmt.Sprintf("%sdata%d", folder, i), bytes.Repeat([]byte("x"), size), started)
+	}
+}
+
+func (f *fakeS3) putMultipartUpload(key, uploadID string, initiated time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = append(f.uploads, types.MultipartUpload{Key: aws.String(key), UploadId: aws.String(uploadID), Initiated: aws.Time(initiated)})
+	sort.SliceStable(f.uploads, func(i, j int) bool {
+		a, b := f.uploads[i], f.uploads[j]
+		if *a.Key != *b.Key {
+			return *a.Key < *b.Key
+		}
+		return *a.UploadId < *b.UploadId
+	})
+}
+
+// keys returns the keys with a current version that isn't a delete marker.
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key, versions := range f.objects {
+		if len(versions) > 0 && !versions[len(versions)-1].deleteMarker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) uploadIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, u := range f.uploads {
+		ids = append(ids, *u.UploadId)
+	}
+	return ids
+}
+
+// callsOf returns the logged calls of op.
+func (f *fakeS3) callsOf(op string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []string
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, op+" ") {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// call logs a request and returns the error injected for it, if any. mu
+// must be held.
+func (f *fakeS3) call(op, arg string) error {
+	f.calls = append(f.calls, op+" "+arg)
+	if len(f.fail[op]) == 0 {
+		return nil
+	}
+	err := f.fail[op][0]
+	f.fail[op] = f.fail[op][1:]
+	return err
+}
+
+func (f *fakeS3) limit(max *int32) int {
+	n := f.pageSize
+	if n == 0 {
+		n = 1000
+	}
+	if max != nil && int(*max) < n {
+		n = int(*max)
+	}
+	return n
+}
+
+func (f *fakeS3) sortedKeys(prefix string) []string {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) ListObjectsV2(_ context.Context, params *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	token := aws.ToString(params.ContinuationToken)
+	if err := f.call("ListObjectsV2", "prefix="+aws.ToString(params.Prefix)+" token="+token); err != nil {
+		return nil, err
+	}
+
+	prefix, delimiter := aws.ToString(params.Prefix), aws.ToString(params.Delimiter)
+	after := token
+	if after == "" {
+		after = aws.ToString(params.StartAfter)
+	}
+
+	out := &s3.ListObjectsV2Output{}
+	seen := map[string]bool{}
+	n, limit := 0, f.limit(params.MaxKeys)
+	for _, key := range f.sortedKeys(prefix) {
+		if key <= after {
+			continue
+		}
+		current := f.objects[key][len(f.objects[key])-1]
+		if current.deleteMarker {
+			continue
+		}
+
+		cp := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				cp = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if cp != "" && seen[cp] {
+			continue
+		}
+		if n == limit {
+			out.IsTruncated = aws.Bool(true)
+			break
+		}
+		if cp != "" {
+			seen[cp] = true
+			out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(cp)})
+			// Sorts after every key below the common prefix.
+			out.NextContinuationToken = aws.String(cp + "\xff")
+			n++
+			continue
+		}
+
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(current.body))),
+			LastModified: aws.Time(current.modified),
+		})
+		out.NextContinuationToken = aws.String(key)
+		n++
+	}
+	if !aws.ToBool(out.IsTruncated) {
+		out.NextContinuationToken = nil
+	}
+	return out, nil
+}
+
+func (f *fakeS3) ListObjectVersions(_ context.Context, params *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keyMarker, idMarker := aws.ToString(params.KeyMarker), aws.ToString(params.VersionIdMarker)
+	if err := f.call("ListObjectVersions", "prefix="+aws.ToString(params.Prefix)+" marker="+keyMarker+"/"+idMarker); err != nil {
+		return nil, err
+	}
+
+	out := &s3.ListObjectVersionsOutput{}
+	n, limit := 0, f.limit(params.MaxKeys)
+	for _, key := range f.sortedKeys(aws.ToString(params.Prefix)) {
+		versions := f.objects[key]
+		// Newest first, like S3 lists them, so the version IDs of a key
+		// descend.
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			if key < keyMarker || key == keyMarker && (idMarker == "" || v.id >= idMarker) {
+				continue
+			}
+			if n == limit {
+				out.IsTruncated = aws.Bool(true)
+				return out, nil
+			}
+			latest := i == len(versions)-1
+			if v.deleteMarker {
+				out.DeleteMarkers = append(out.DeleteMarkers, types.DeleteMarkerEntry{
+					Key: aws.String(key), VersionId: aws.String(v.id), IsLatest: aws.Bool(latest), LastModified: aws.Time(v.modified),
+				})
+			} else {
+				out.Versions = append(out.Versions, types.ObjectVersion{
+					Key: aws.String(key), VersionId: aws.String(v.id), IsLatest: aws.Bool(latest),
+					Size: aws.Int64(int64(len(v.body))), LastModified: aws.Time(v.modified),
+				})
+			}
+			out.NextKeyMarker, out.NextVersionIdMarker = aws.String(key), aws.String(v.id)
+			n++
+		}
+	}
+	out.NextKeyMarker, out.NextVersionIdMarker = nil, nil
+	return out, nil
+}
+
+func (f *fakeS3) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetObject", *params.Key); err != nil {
+		return nil, err
+	}
+	versions := f.objects[*params.Key]
+	if len(versions) == 0 || versions[len(versions)-1].deleteMarker {
+		return nil, &types.NoSuchKey{}
+	}
+	v := versions[len(versions)-1]
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(v.body)),
+		ContentLength: aws.Int64(int64(len(v.body))),
+		ETag:          aws.String(v.etag()),
+		LastModified:  aws.Time(v.modified),
+	}, nil
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("HeadObject", *params.Key); err != nil {
+		return nil, err
+	}
+	versions := f.objects[*params.Key]
+	if len(versions) == 0 || versions[len(versions)-1].deleteMarker {
+		return nil, &smithy.GenericAPIError{Code: "NotFound"}
+	}
+	v := versions[len(versions)-1]
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(v.body))), LastModified: aws.Time(v.modified)}, nil
+}
+
+func (f *fakeS3) CopyObject(_ context.Context, params *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CopyObject", *params.Key); err != nil {
+		return nil, err
+	}
+	source, _, _ := strings.Cut(aws.ToString(params.CopySource), "?")
+	_, key, _ := strings.Cut(source, "/")
+	versions := f.objects[key]
+	if len(versions) == 0 {
+		return nil, &types.NoSuchKey{}
+	}
+	current := versions[len(versions)-1]
+	f.putLocked(*params.Key, fakeVersion{body: current.body, modified: time.Now()})
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// etag returns the ETag of v, the MD5 of the body like S3 does for objects
+// uploaded in one request.
+func (v fakeVersion) etag() string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(v.body)))
+}
+
+func (f *fakeS3) PutObject(_ context.Context, params *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("PutObject", *params.Key); err != nil {
+		return nil, err
+	}
+	versions := f.objects[*params.Key]
+	exists := len(versions) > 0 && !versions[len(versions)-1].deleteMarker
+	if aws.ToString(params.IfNoneMatch) == "*" && exists {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	if params.IfMatch != nil && (!exists || versions[len(versions)-1].etag() != *params.IfMatch) {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+	var body []byte
+	if params.Body != nil {
+		body, _ = io.ReadAll(params.Body)
+	}
+	f.putLocked(*params.Key, fakeVersion{body: body, modified: time.Now()})
+	return &s3.PutObjectOutput{}, nil
+}
+
+// deleteLocked deletes key, or one version of it, like DeleteObject does.
+func (f *fakeS3) deleteLocked(key, versionID string) {
+	versions := f.objects[key]
+	switch {
+	case versionID != "":
+		for i, v := range versions {
+			if v.id == versionID {
+				versions = append(versions[:i:i], versions[i+1:]...)
+				break
+			}
+		}
+	case f.versioned:
+		f.putLocked(key, fakeVersion{deleteMarker: true, modified: time.Now()})
+		return
+	default:
+		versions = nil
+	}
+	if len(versions) == 0 {
+		delete(f.objects, key)
+	} else {
+		f.objects[key] = versions
+	}
+}
+
+func (f *fakeS3) DeleteObject(_ context.Context, params *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteObject", *params.Key); err != nil {
+		return nil, err
+	}
+	f.deleteLocked(*params.Key, aws.ToString(params.VersionId))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjects(_ context.Context, params *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for _, o := range params.Delete.Objects {
+		keys = append(keys, *o.Key)
+	}
+	if err := f.call("DeleteObjects", strings.Join(keys, ",")); err != nil {
+		return nil, err
+	}
+
+	out := &s3.DeleteObjectsOutput{}
+	for _, o := range params.Delete.Objects {
+		key := *o.Key
+		if codes := f.deleteErrs[key]; len(codes) > 0 {
+			code := codes[0]
+			f.deleteErrs[key] = codes[1:]
+			if code != "" {
+				out.Errors = append(out.Errors, types.Error{Key: o.Key, VersionId: o.VersionId, Code: aws.String(code), Message: aws.String(code + " injected")})
+				continue
+			}
+		}
+		f.deleteLocked(key, aws.ToString(o.VersionId))
+		out.Deleted = append(out.Deleted, types.DeletedObject{Key: o.Key, VersionId: o.VersionId})
+	}
+	return out, nil
+}
+
+func (f *fakeS3) GetBucketVersioning(_ context.Context, _ *s3.GetBucketVersioningInput, _ ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetBucketVersioning", ""); err != nil {
+		return nil, err
+	}
+	if f.versioned {
+		return &s3.GetBucketVersioningOutput{Status: types.BucketVersioningStatusEnabled}, nil
+	}
+	return &s3.GetBucketVersioningOutput{}, nil
+}
+
+func (f *fakeS3) HeadBucket(_ context.Context, _ *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("HeadBucket", ""); err != nil {
+		return nil, err
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (f *fakeS3) ListMultipartUploads(_ context.Context, params *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keyMarker, idMarker := aws.ToString(params.KeyMarker), aws.ToString(params.UploadIdMarker)
+	if err := f.call("ListMultipartUploads", "prefix="+aws.ToString(params.Prefix)+" marker="+keyMarker+"/"+idMarker); err != nil {
+		return nil, err
+	}
+
+	out := &s3.ListMultipartUploadsOutput{}
+	limit := f.limit(params.MaxUploads)
+	for _, u := range f.uploads {
+		if !strings.HasPrefix(*u.Key, aws.ToString(params.Prefix)) {
+			continue
+		}
+		// Like S3, the upload ID marker only applies to the key marker's
+		// uploads; without it that key is skipped entirely.
+		if *u.Key < keyMarker || *u.Key == keyMarker && (idMarker == "" || *u.UploadId <= idMarker) {
+			continue
+		}
+		if len(out.Uploads) == limit {
+			out.IsTruncated = aws.Bool(true)
+			last := out.Uploads[len(out.Uploads)-1]
+			out.NextKeyMarker, out.NextUploadIdMarker = last.Key, last.UploadId
+			return out, nil
+		}
+		out.Uploads = append(out.Uploads, u)
+	}
+	return out, nil
+}
+
+func (f *fakeS3) ListParts(_ context.Context, params *s3.ListPartsInput, _ ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListParts", *params.UploadId); err != nil {
+		return nil, err
+	}
+	for _, u := range f.uploads {
+		if *u.Key == *params.Key && *u.UploadId == *params.UploadId {
+			return &s3.ListPartsOutput{Parts: []types.Part{{PartNumber: aws.Int32(1), Size: aws.Int64(5 << 20)}}}, nil
+		}
+	}
+	return nil, &types.NoSuchUpload{}
+}
+
+func (f *fakeS3) AbortMultipartUpload(_ context.Context, params *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("AbortMultipartUpload", *params.UploadId); err != nil {
+		return nil, err
+	}
+	for i, u := range f.uploads {
+		if *u.Key == *params.Key && *u.UploadId == *params.UploadId {
+			f.uploads = append(f.uploads[:i:i], f.uploads[i+1:]...)
+			return &s3.AbortMultipartUploadOutput{}, nil
+		}
+	}
+	return nil, &types.NoSuchUpload{}
+}
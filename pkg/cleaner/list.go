@@ -0,0 +1,154 @@
+package cleaner
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// listEntry is a row of the --list output.
+type listEntry struct {
+	Bucket     string    `json:"bucket"`
+	Repository string    `json:"repository"`
+	Key        string    `json:"key"`
+	UploadID   string    `json:"upload_id,omitempty"`
+	Type       string    `json:"type"`
+	Started    time.Time `json:"started"`
+	AgeHours   int       `json:"age_hours"`
+	Size       *int64    `json:"size_bytes"`
+}
+
+var listHeader = []string{"bucket", "repository", "key", "upload_id", "type", "started", "age_hours", "size_bytes"}
+
+// listEntries returns the candidates that would be removed, the oldest
+// first, limited to --list-limit rows, and their number before the limit.
+func (cl *Cleaner) listEntries(summaries []*runSummary) ([]listEntry, int) {
+	var candidates []candidate
+	for _, r := range summaries {
+		candidates = append(candidates, r.removed...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].started.Before(candidates[j].started)
+	})
+
+	total := len(candidates)
+	if cl.cfg.ListLimit > 0 && len(candidates) > cl.cfg.ListLimit {
+		candidates = candidates[:cl.cfg.ListLimit]
+	}
+
+	entries := make([]listEntry, 0, len(candidates))
+	for _, c := range candidates {
+		e := listEntry{
+			Bucket:     c.bucket,
+			Repository: c.repository(),
+			Key:        c.key,
+			UploadID:   c.uploadID,
+			Type:       c.kind,
+			Started:    c.started.UTC().Truncate(time.Second),
+			AgeHours:   c.hours,
+		}
+		if c.size >= 0 {
+			size := c.size
+			e.Size = &size
+		}
+		entries = append(entries, e)
+	}
+	return entries, total
+}
+
+// printList writes the candidates of a --list run to the Output in the
+// ListFormat. Errors of the run mean candidates may be missing, they are
+// printed to stderr so they don't end up in piped JSON or CSV.
+func (cl *Cleaner) printList(summaries []*runSummary) {
+	entries, total := cl.listEntries(summaries)
+
+	var err error
+	switch cl.cfg.ListFormat {
+	case "json":
+		enc := json.NewEncoder(cl.listOut)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	case "csv":
+		err = writeListCSV(cl.listOut, entries)
+	default:
+		cl.printListTable(entries, total)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: writing the list: %s\n", err)
+	}
+
+	for _, r := range summaries {
+		for _, err := range r.errs {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		}
+	}
+	if errs := countErrors(summaries); errs > 0 {
+		fmt.Fprintf(os.Stderr, "%d errors, the list may be incomplete\n", errs)
+	}
+}
+
+func (cl *Cleaner) printListTable(entries []listEntry, total int) {
+	if len(entries) == 0 {
+		fmt.Fprintf(cl.listOut, "No candidates older than %s\n", cl.describeThreshold())
+		return
+	}
+
+	w := tabwriter.NewWriter(cl.listOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Repository\tKey\tType\tStarted\tAge\tSize")
+	for _, e := range entries {
+		size := ""
+		if e.Size != nil {
+			size = FormatBytes(*e.Size)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Repository, e.Key, e.Type,
+			e.Started.Format(time.RFC3339), formatAge(e.AgeHours), size)
+	}
+	w.Flush()
+
+	if len(entries) < total {
+		fmt.Fprintf(cl.listOut, "\n%d of %d candidates, --list-limit 0 lists all\n", len(entries), total)
+	}
+}
+
+func writeListCSV(out io.Writer, entries []listEntry) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(listHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		size := ""
+		if e.Size != nil {
+			size = strconv.FormatInt(*e.Size, 10)
+		}
+		row := []string{e.Bucket, e.Repository, e.Key, e.UploadID, e.Type,
+			e.Started.Format(time.RFC3339), strconv.Itoa(e.AgeHours), size}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// formatAge formats an age in hours as days and hours, e.g. 3d4h.
+func formatAge(hours int) string {
+	if hours < 24 {
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dd%dh", hours/24, hours%24)
+}
+
+func countErrors(summaries []*runSummary) int {
+	n := 0
+	for _, r := range summaries {
+		n += len(r.errs)
+	}
+	return n
+}
@@ -0,0 +1,42 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListLimit(t *testing.T) {
+	newFake := func() *fakeS3 {
+		f := newFakeS3()
+		for i := 0; i < 25; i++ {
+			f.putUpload(fmt.Sprintf("%srepo/_uploads/u%02d/", testRepositories, i), time.Now().Add(-time.Duration(48+i)*time.Hour), 1)
+		}
+		return f
+	}
+
+	// More candidates than the default --top, all of them listed.
+	cl, out := newTestCleaner(t, newFake(), Config{List: true, ListFormat: "json", Top: 20})
+	run(t, cl, out)
+	var entries []listEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(entries) != 25 {
+		t.Errorf("listed %d candidates, want all 25", len(entries))
+	}
+
+	cl, out = newTestCleaner(t, newFake(), Config{List: true, ListLimit: 3})
+	run(t, cl, out)
+	if n := strings.Count(out.String(), "/_uploads/"); n != 3 {
+		t.Errorf("listed %d candidates, want 3\n%s", n, out)
+	}
+	if !strings.Contains(out.String(), testRepositories+"repo/_uploads/u24/") {
+		t.Errorf("oldest candidate not listed\n%s", out)
+	}
+	if !strings.Contains(out.String(), "3 of 25 candidates, --list-limit 0 lists all") {
+		t.Errorf("no footer about the limit\n%s", out)
+	}
+}
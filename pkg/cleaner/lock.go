@@ -0,0 +1,171 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// Name of the lock object created with Lock, in the root directory.
+const lockObject = ".s3-upload-cleaner.lock"
+
+// DefaultLockTTL is used for a zero Config.LockTTL.
+const DefaultLockTTL = 6 * time.Hour
+
+// ErrLocked is returned by Run when another run holds the lock of one of
+// the buckets or root directories.
+var ErrLocked = errors.New("another run holds the lock")
+
+// lockInfo is the content of a lock object.
+type lockInfo struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+func (l lockInfo) same(o lockInfo) bool {
+	return l.Host == o.Host && l.PID == o.PID && l.Started.Equal(o.Started)
+}
+
+func (l lockInfo) String() string {
+	return fmt.Sprintf("%s (pid %d) since %s", l.Host, l.PID, l.Started.Format(time.RFC3339))
+}
+
+// heldLock is a lock object created by this run.
+type heldLock struct {
+	bucket string
+	key    string
+	info   lockInfo
+}
+
+func lockKey(rootDir string) string {
+	if rootDir == "" {
+		return lockObject
+	}
+	return rootDir + "/" + lockObject
+}
+
+// acquireLocks creates the lock object of every bucket and root directory
+// of the run. When one of them is held by another run, the locks taken so
+// far are released again and an error wrapping ErrLocked is returned.
+func (cl *Cleaner) acquireLocks(ctx context.Context, started time.Time) ([]heldLock, error) {
+	host, _ := os.Hostname()
+	info := lockInfo{Host: host, PID: os.Getpid(), Started: started.UTC()}
+
+	var held []heldLock
+	for _, bucket := range cl.buckets {
+		for _, rootDir := range cl.rootDirs {
+			l := heldLock{bucket: bucket, key: lockKey(rootDir), info: info}
+			if err := cl.acquireLock(ctx, l); err != nil {
+				cl.releaseLocks(held)
+				return nil, err
+			}
+			held = append(held, l)
+		}
+	}
+	return held, nil
+}
+
+// acquireLock creates the lock object l, or takes it over when it is older
+// than the lock TTL. The writes are conditional, so of two runs starting
+// at the same time only one gets the lock on backends supporting
+// If-None-Match and If-Match; on others the check of the existing object
+// still catches runs that don't overlap their start.
+func (cl *Cleaner) acquireLock(ctx context.Context, l heldLock) error {
+	existing, etag, err := cl.readLock(ctx, l.bucket, l.key)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("reading lock s3://%s/%s: %w", l.bucket, l.key, err)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(l.bucket),
+		Key:         aws.String(l.key),
+		ContentType: aws.String("application/json"),
+		IfNoneMatch: aws.String("*"),
+	}
+
+	if err == nil {
+		age := time.Since(existing.Started)
+		if age < cl.cfg.LockTTL {
+			return fmt.Errorf("%w: s3://%s/%s is held by %s", ErrLocked, l.bucket, l.key, existing)
+		}
+		cl.printf("WARNING: taking over the lock s3://%s/%s held by %s, older than %s\n", l.bucket, l.key, existing, cl.cfg.LockTTL)
+		input.IfNoneMatch, input.IfMatch = nil, etag
+	}
+
+	body, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	input.Body = bytes.NewReader(body)
+
+	if _, err := cl.client.PutObject(ctx, input); err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			return fmt.Errorf("%w: s3://%s/%s was created by another run just now", ErrLocked, l.bucket, l.key)
+		}
+		return fmt.Errorf("creating lock s3://%s/%s: %w", l.bucket, l.key, err)
+	}
+
+	cl.printf("Lock: s3://%s/%s\n", l.bucket, l.key)
+	return nil
+}
+
+func (cl *Cleaner) readLock(ctx context.Context, bucket, key string) (lockInfo, *string, error) {
+	var info lockInfo
+
+	obj, err := cl.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return info, nil, err
+	}
+	defer obj.Body.Close()
+
+	content, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return info, nil, err
+	}
+	// An unreadable lock counts as a very old one, so it gets taken over.
+	if err := json.Unmarshal(content, &info); err != nil {
+		cl.printf("WARNING: lock s3://%s/%s has invalid content: %s\n", bucket, key, err)
+	}
+	return info, obj.ETag, nil
+}
+
+// releaseLocks deletes the lock objects, unless another run took them
+// over in the meantime. It doesn't use the context of the run, so locks
+// are released after a timeout or an interrupt too.
+func (cl *Cleaner) releaseLocks(held []heldLock) {
+	ctx, cancel := context.WithTimeout(context.Background(), cl.cfg.RequestTimeout)
+	defer cancel()
+
+	for _, l := range held {
+		current, _, err := cl.readLock(ctx, l.bucket, l.key)
+		if err != nil {
+			cl.printf("WARNING: can't read lock s3://%s/%s to release it: %s\n", l.bucket, l.key, err)
+			continue
+		}
+		if !current.same(l.info) {
+			cl.printf("WARNING: lock s3://%s/%s was taken over by %s, leaving it\n", l.bucket, l.key, current)
+			continue
+		}
+
+		if _, err := cl.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(l.bucket),
+			Key:    aws.String(l.key),
+		}); err != nil {
+			cl.printf("WARNING: can't release lock s3://%s/%s: %s\n", l.bucket, l.key, err)
+		}
+	}
+}
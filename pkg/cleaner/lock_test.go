@@ -0,0 +1,156 @@
+package cleaner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// putLock stores a lock object of another run started at started.
+func putLock(t *testing.T, f *fakeS3, key string, started time.Time) lockInfo {
+	t.Helper()
+	info := lockInfo{Host: "other-host", PID: 4242, Started: started.UTC().Truncate(time.Second)}
+	body, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put(key, body, started)
+	return info
+}
+
+func readFakeLock(t *testing.T, f *fakeS3, key string) (lockInfo, bool) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	versions := f.objects[key]
+	if len(versions) == 0 {
+		return lockInfo{}, false
+	}
+	var info lockInfo
+	if err := json.Unmarshal(versions[len(versions)-1].body, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info, true
+}
+
+// putRecorder is a fakeS3 recording the PutObject requests.
+type putRecorder struct {
+	*fakeS3
+	puts *[]*s3.PutObjectInput
+}
+
+func (p putRecorder) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	*p.puts = append(*p.puts, params)
+	return p.fakeS3.PutObject(ctx, params, optFns...)
+}
+
+// cancelOnList is a fakeS3 canceling the run at the first listing, like an
+// interrupt does.
+type cancelOnList struct {
+	*fakeS3
+	cancel context.CancelFunc
+}
+
+func (c cancelOnList) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.cancel()
+	return c.fakeS3.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestLockHeldByAnotherRun(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/a/", old, 100)
+
+	cl, out := newTestCleaner(t, f, Config{Lock: true})
+	key := lockKey(cl.rootDirs[0])
+	info := putLock(t, f, key, time.Now().Add(-time.Hour))
+
+	_, err := cl.Run(context.Background())
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("Run returned %v, want ErrLocked\n%s", err, out)
+	}
+	if current, _ := readFakeLock(t, f, key); !current.same(info) {
+		t.Errorf("lock changed to %s, want it left to %s", current, info)
+	}
+	if len(f.callsOf("DeleteObjects")) != 0 || len(f.keys()) != 3 {
+		t.Errorf("locked run removed objects, left %v", f.keys())
+	}
+}
+
+func TestLockStaleTakenOver(t *testing.T) {
+	f := newFakeS3()
+	cl, out := newTestCleaner(t, f, Config{Lock: true})
+	key := lockKey(cl.rootDirs[0])
+	putLock(t, f, key, time.Now().Add(-2*DefaultLockTTL))
+
+	f.mu.Lock()
+	etag := f.objects[key][0].etag()
+	f.mu.Unlock()
+
+	var puts []*s3.PutObjectInput
+	cl.client = putRecorder{fakeS3: f, puts: &puts}
+	run(t, cl, out)
+
+	if len(puts) != 1 {
+		t.Fatalf("%d PutObject requests, want 1", len(puts))
+	}
+	if aws.ToString(puts[0].IfMatch) != etag || puts[0].IfNoneMatch != nil {
+		t.Errorf("lock taken over with If-Match %q, If-None-Match %q, want If-Match %q only",
+			aws.ToString(puts[0].IfMatch), aws.ToString(puts[0].IfNoneMatch), etag)
+	}
+	if _, ok := readFakeLock(t, f, key); ok {
+		t.Error("lock taken over left after the run")
+	}
+}
+
+func TestLockPreconditionFailed(t *testing.T) {
+	f := newFakeS3()
+	f.fail["PutObject"] = []error{&smithy.GenericAPIError{Code: "PreconditionFailed"}}
+
+	cl, out := newTestCleaner(t, f, Config{Lock: true})
+	if _, err := cl.Run(context.Background()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Run returned %v, want ErrLocked\n%s", err, out)
+	}
+}
+
+func TestReleaseLocksTakenOver(t *testing.T) {
+	f := newFakeS3()
+	cl, _ := newTestCleaner(t, f, Config{Lock: true})
+	key := lockKey(cl.rootDirs[0])
+
+	held, err := cl.acquireLocks(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := putLock(t, f, key, time.Now())
+
+	cl.releaseLocks(held)
+	if current, ok := readFakeLock(t, f, key); !ok || !current.same(other) {
+		t.Errorf("lock of the run that took it over released")
+	}
+}
+
+func TestLockReleasedWhenInterrupted(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/a/", old, 100)
+
+	cl, out := newTestCleaner(t, f, Config{Lock: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cl.client = cancelOnList{fakeS3: f, cancel: cancel}
+
+	report, _ := cl.Run(ctx)
+	if !report.Interrupted {
+		t.Errorf("run not interrupted\n%s", out)
+	}
+	if _, ok := readFakeLock(t, f, lockKey(cl.rootDirs[0])); ok {
+		t.Errorf("lock left after the interrupted run\n%s", out)
+	}
+}
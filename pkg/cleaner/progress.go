@@ -0,0 +1,51 @@
+package cleaner
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// progress counts the work done so far for the periodic progress line.
+// The counters are atomic so they can be updated from any goroutine.
+type progress struct {
+	prefixesFound  atomic.Int64
+	prefixesDone   atomic.Int64
+	mpusAborted    atomic.Int64
+	foldersRemoved atomic.Int64
+	objectsDeleted atomic.Int64
+	apiCalls       atomic.Int64
+}
+
+// reportProgress prints a progress line every ProgressInterval until the
+// returned function is called.
+func (cl *Cleaner) reportProgress(started time.Time) (stop func()) {
+	if cl.cfg.ProgressInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		ticker := time.NewTicker(cl.cfg.ProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				p := &cl.progress
+				cl.printf("Progress: %d/%d prefixes, %d MPUs aborted, %d folders removed, %d objects deleted, %d API calls, %s elapsed\n",
+					p.prefixesDone.Load(), p.prefixesFound.Load(), p.mpusAborted.Load(), p.foldersRemoved.Load(),
+					p.objectsDeleted.Load(), p.apiCalls.Load(), time.Since(started).Round(time.Second))
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
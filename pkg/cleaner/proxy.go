@@ -0,0 +1,136 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyFromEnvironment is the proxy of the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables, a variable so tests can replace it: the
+// net/http one reads the environment only once.
+var proxyFromEnvironment = http.ProxyFromEnvironment
+
+// proxyFunc returns the Proxy function of the transport: the --proxy URL,
+// or without --proxy the proxy of the HTTP_PROXY and HTTPS_PROXY
+// environment variables, except for the hosts of --no-proxy. NO_PROXY
+// applies when --no-proxy isn't given.
+func (cl *Cleaner) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if cl.cfg.Proxy == "" {
+		if cl.cfg.NoProxy == "" {
+			return proxyFromEnvironment, nil
+		}
+		noProxy := cl.cfg.NoProxy
+		return func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(noProxy, req.URL) {
+				return nil, nil
+			}
+			return proxyFromEnvironment(req)
+		}, nil
+	}
+
+	proxy := cl.cfg.Proxy
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --proxy: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid --proxy %s: the scheme must be http, https or socks5", proxyURL.Redacted())
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid --proxy %s: no host", proxyURL.Redacted())
+	}
+
+	noProxy := cl.cfg.NoProxy
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+	}
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(noProxy, req.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// describeProxy returns the proxy requests to endpoint go through for the
+// banner, without its password, or "" for none.
+func describeProxy(proxy func(*http.Request) (*url.URL, error), endpoint string) string {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return ""
+	}
+	proxyURL, err := proxy(req)
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+	return proxyURL.Redacted()
+}
+
+// bypassProxy reports whether requests to u are excluded from the proxy by
+// noProxy, a comma separated list in the format of NO_PROXY: "*" for all
+// hosts, host names matching themselves and their subdomains (with or
+// without a leading dot), IP addresses and CIDR ranges, each optionally
+// with a port.
+func bypassProxy(noProxy string, u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProxyError reports whether err failed connecting to the proxy rather
+// than to the endpoint.
+func isProxyError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "proxyconnect"
+}
@@ -0,0 +1,71 @@
+package cleaner
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		noProxy string
+		url     string
+		bypass  bool
+	}{
+		{"", "https://s3.example.com", false},
+		{"*", "https://s3.example.com", true},
+		{"example.com", "https://s3.example.com", true},
+		{".example.com", "https://s3.example.com", true},
+		{"example.com", "https://s3.example.org", false},
+		{"ample.com", "https://s3.example.com", false},
+		{"s3.example.com:9000", "https://s3.example.com:9000", true},
+		{"s3.example.com:9000", "https://s3.example.com", false},
+		{"10.0.0.0/8", "http://10.1.2.3:9000", true},
+		{"10.0.0.0/8", "http://192.168.1.1:9000", false},
+		{"192.168.1.1", "http://192.168.1.1:9000", true},
+		{"other.com, S3.Example.com", "https://s3.example.com", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bypassProxy(tt.noProxy, u); got != tt.bypass {
+			t.Errorf("bypassProxy(%q, %s) = %t, want %t", tt.noProxy, tt.url, got, tt.bypass)
+		}
+	}
+}
+
+func TestProxyFuncNoProxy(t *testing.T) {
+	envProxy, _ := url.Parse("http://env-proxy:3128")
+	saved := proxyFromEnvironment
+	proxyFromEnvironment = func(*http.Request) (*url.URL, error) { return envProxy, nil }
+	defer func() { proxyFromEnvironment = saved }()
+
+	tests := []struct {
+		name  string
+		cfg   Config
+		url   string
+		proxy string
+	}{
+		{"environment", Config{}, "https://s3.example.com", "http://env-proxy:3128"},
+		{"environment with --no-proxy", Config{NoProxy: "example.com"}, "https://s3.example.com", ""},
+		{"environment, other host", Config{NoProxy: "example.com"}, "https://s3.example.org", "http://env-proxy:3128"},
+		{"--proxy", Config{Proxy: "proxy:3128"}, "https://s3.example.com", "http://proxy:3128"},
+		{"--proxy with --no-proxy", Config{Proxy: "proxy:3128", NoProxy: "s3.example.com"}, "https://s3.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &Cleaner{cfg: tt.cfg}
+			proxy, err := cl.proxyFunc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describeProxy(proxy, tt.url); got != tt.proxy {
+				t.Errorf("proxy of %s = %q, want %q", tt.url, got, tt.proxy)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package cleaner
+
+import (
+	"strings"
+	"time"
+)
+
+// Number of error messages included in a Report.
+const reportErrors = 10
+
+// Report summarizes a whole run. It is also the JSON payload of the
+// s3-upload-cleaner webhook notification.
+type Report struct {
+	Started        time.Time `json:"start_time"`
+	Finished       time.Time `json:"end_time"`
+	Bucket         string    `json:"bucket"`
+	DryRun         bool      `json:"dry_run"`
+	MPUsAborted    int       `json:"mpus_aborted"`
+	FoldersRemoved int       `json:"folders_removed"`
+	ObjectsDeleted int64     `json:"objects_deleted"`
+	Placeholders   int       `json:"placeholders_deleted"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+	ErrorCount     int       `json:"error_count"`
+	Errors         []string  `json:"errors"`
+
+	// In a dry run BytesReclaimed is what would be reclaimed, without the
+	// UnsizedMPUs, the multipart uploads of unknown size that would be
+	// aborted.
+	UnsizedMPUs int `json:"unsized_mpus,omitempty"`
+
+	Repositories []RepositoryReport `json:"repositories"`
+
+	// DurationSeconds is the wall-clock time of the run, APICalls the
+	// requests it made by S3 operation.
+	DurationSeconds float64                 `json:"duration_seconds"`
+	APICalls        map[string]APICallStats `json:"api_calls"`
+
+	// TimedOut is set when the Timeout or the deadline of the context
+	// expired before the run completed, Interrupted when the context was
+	// canceled, and FailedFast when FailFast stopped the run at an error.
+	TimedOut    bool `json:"-"`
+	Interrupted bool `json:"-"`
+	FailedFast  bool `json:"-"`
+}
+
+// RepositoryReport adds up what was removed from one repository.
+type RepositoryReport struct {
+	Name           string `json:"name"`
+	MPUsAborted    int    `json:"mpus_aborted"`
+	FoldersRemoved int    `json:"folders_removed"`
+	BytesReclaimed int64  `json:"bytes_reclaimed"`
+}
+
+func (cl *Cleaner) newReport(started time.Time, summaries []*runSummary) Report {
+	report := Report{
+		Started:  started.UTC(),
+		Finished: time.Now().UTC(),
+		Bucket:   strings.Join(cl.buckets, ","),
+		DryRun:   cl.cfg.DryRun,
+		Errors:   []string{},
+
+		ObjectsDeleted: cl.progress.objectsDeleted.Load(),
+
+		Repositories: []RepositoryReport{},
+
+		APICalls: cl.apiStats.snapshot(),
+	}
+	report.DurationSeconds = report.Finished.Sub(report.Started).Seconds()
+
+	for _, t := range cl.topRepositories(summaries) {
+		report.Repositories = append(report.Repositories, RepositoryReport{
+			Name:           t.name,
+			MPUsAborted:    t.mpus,
+			FoldersRemoved: t.folders,
+			BytesReclaimed: t.bytes,
+		})
+	}
+
+	for _, r := range summaries {
+		report.MPUsAborted += r.mpusRemoved
+		report.FoldersRemoved += r.foldersRemoved + r.orphansRemoved + r.emptyRemoved + r.deletedRemoved
+		report.Placeholders += r.placeholdersRemoved
+		report.BytesReclaimed += r.bytesReclaimed
+		report.UnsizedMPUs += r.unsized
+		report.ErrorCount += len(r.errs)
+
+		for _, err := range r.errs {
+			if len(report.Errors) < reportErrors {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,182 @@
+package cleaner
+
+import (
+	"html/template"
+	"os"
+	"time"
+)
+
+type htmlReport struct {
+	Started   string
+	Duration  time.Duration
+	Endpoint  string
+	DryRun    bool
+	Threshold string
+	Sections  []htmlSection
+}
+
+type htmlSection struct {
+	Label          string
+	MPUsRemoved    int
+	FoldersRemoved int
+	BytesReclaimed string
+	StoppedAt      string
+	Repositories   []htmlRepository
+	MPUs           []htmlCandidate
+	Folders        []htmlCandidate
+	Errors         []error
+}
+
+type htmlCandidate struct {
+	Key      string
+	UploadID string
+	Started  string
+	Hours    int
+	Size     string
+}
+
+type htmlRepository struct {
+	Name    string
+	MPUs    int
+	Folders int
+	Bytes   string
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>s3-upload-cleaner report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+td.num { text-align: right; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>s3-upload-cleaner report</h1>
+<table>
+<tr><th>Endpoint</th><td>{{.Endpoint}}</td></tr>
+<tr><th>Started</th><td>{{.Started}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Threshold</th><td>{{.Threshold}}</td></tr>
+<tr><th>Dry run</th><td>{{.DryRun}}</td></tr>
+</table>
+{{range .Sections}}
+<h2>{{.Label}}</h2>
+{{if .StoppedAt}}<p class="error">Run timed out, processing stopped at {{.StoppedAt}}</p>{{end}}
+<table>
+<tr><th>MPUs removed</th><td class="num">{{.MPUsRemoved}}</td></tr>
+<tr><th>Upload folders removed</th><td class="num">{{.FoldersRemoved}}</td></tr>
+<tr><th>Bytes reclaimed</th><td class="num">{{.BytesReclaimed}}</td></tr>
+<tr><th>Errors</th><td class="num">{{len .Errors}}</td></tr>
+</table>
+{{if .Repositories}}
+<table>
+<tr><th>Repository</th><th>MPUs</th><th>Folders</th><th>Reclaimed</th></tr>
+{{range .Repositories}}<tr><td>{{.Name}}</td><td class="num">{{.MPUs}}</td><td class="num">{{.Folders}}</td><td class="num">{{.Bytes}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .MPUs}}
+<details>
+<summary>Aborted multipart uploads ({{len .MPUs}})</summary>
+<table>
+<tr><th>Key</th><th>Upload ID</th><th>Initiated</th><th>Age (hours)</th></tr>
+{{range .MPUs}}<tr><td>{{.Key}}</td><td>{{.UploadID}}</td><td>{{.Started}}</td><td class="num">{{.Hours}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+{{if .Folders}}
+<details>
+<summary>Removed upload folders ({{len .Folders}})</summary>
+<table>
+<tr><th>Folder</th><th>Started</th><th>Age (hours)</th><th>Size</th></tr>
+{{range .Folders}}<tr><td>{{.Key}}</td><td>{{.Started}}</td><td class="num">{{.Hours}}</td><td class="num">{{.Size}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+{{if .Errors}}
+<details open>
+<summary class="error">Errors ({{len .Errors}})</summary>
+<ul>
+{{range .Errors}}<li class="error">{{.}}</li>
+{{end}}</ul>
+</details>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport renders the --report-html file. Failing to write it is
+// reported but doesn't affect the outcome of the cleanup.
+func (cl *Cleaner) writeHTMLReport(path string, started time.Time, summaries []*runSummary) {
+	report := htmlReport{
+		Started:   started.UTC().Format(time.RFC3339),
+		Duration:  time.Since(started).Round(time.Second),
+		Endpoint:  cl.endpoint,
+		DryRun:    cl.cfg.DryRun,
+		Threshold: cl.describeThreshold(),
+	}
+
+	for _, r := range summaries {
+		report.Sections = append(report.Sections, cl.htmlSection(r))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		cl.printf(" ERROR: writing HTML report: %s\n", err)
+		return
+	}
+
+	if err := htmlReportTemplate.Execute(file, report); err != nil {
+		cl.printf(" ERROR: writing HTML report: %s\n", err)
+	}
+
+	if err := file.Close(); err != nil {
+		cl.printf(" ERROR: writing HTML report: %s\n", err)
+	}
+}
+
+func (cl *Cleaner) htmlSection(r *runSummary) htmlSection {
+	section := htmlSection{
+		Label:          cl.label(r),
+		MPUsRemoved:    r.mpusRemoved,
+		FoldersRemoved: r.foldersRemoved + r.orphansRemoved + r.emptyRemoved + r.deletedRemoved,
+		BytesReclaimed: FormatBytes(r.bytesReclaimed),
+		StoppedAt:      r.stoppedAt,
+		Errors:         r.errs,
+	}
+
+	for _, c := range r.removed {
+		row := htmlCandidate{
+			Key:      c.key,
+			UploadID: c.uploadID,
+			Started:  c.started.UTC().Format(time.RFC3339),
+			Hours:    c.hours,
+		}
+		if c.size >= 0 {
+			row.Size = FormatBytes(c.size)
+		}
+
+		if c.kind == "mpu" {
+			section.MPUs = append(section.MPUs, row)
+		} else {
+			section.Folders = append(section.Folders, row)
+		}
+	}
+
+	for _, t := range repositoryTotals(r.removed) {
+		section.Repositories = append(section.Repositories, htmlRepository{
+			Name:    t.name,
+			MPUs:    t.mpus,
+			Folders: t.folders,
+			Bytes:   FormatBytes(t.bytes),
+		})
+	}
+
+	return section
+}
@@ -0,0 +1,344 @@
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+const testRepositories = "docker/registry/v2/repositories/"
+
+func TestMain(m *testing.M) {
+	retryPause = time.Millisecond
+	os.Exit(m.Run())
+}
+
+// newTestCleaner returns a Cleaner of the bucket "bucket" in f, with a 12
+// hours threshold unless cfg sets one, and the buffer its output goes to.
+func newTestCleaner(t *testing.T, f *fakeS3, cfg Config) (*Cleaner, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	cfg.Client, cfg.Endpoint, cfg.Output = f, "fake", &out
+	if cfg.Buckets == nil {
+		cfg.Buckets = []string{"bucket"}
+	}
+	if cfg.CleanupThreshold == 0 && cfg.OlderThan.IsZero() {
+		cfg.CleanupThreshold = 12 * time.Hour
+	}
+	cfg.AllowInactive = true
+
+	cl, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl, &out
+}
+
+func run(t *testing.T, cl *Cleaner, out *bytes.Buffer) Report {
+	t.Helper()
+	report, err := cl.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %s\n%s", err, out)
+	}
+	return report
+}
+
+func TestRunPagination(t *testing.T) {
+	now := time.Now()
+	f := newFakeS3()
+	f.pageSize = 2
+
+	var fresh []string
+	for _, repo := range []string{"a", "b", "c", "d"} {
+		f.putUpload(testRepositories+repo+"/_uploads/old/", now.Add(-48*time.Hour), 10, 20)
+		f.putUpload(testRepositories+repo+"/_uploads/new/", now)
+		fresh = append(fresh, testRepositories+repo+"/_uploads/new/startedat")
+	}
+	for i := 0; i < 5; i++ {
+		f.putMultipartUpload(fmt.Sprintf("%sa/_uploads/m%d/data", testRepositories, i), fmt.Sprintf("old%d", i), now.Add(-48*time.Hour))
+	}
+	f.putMultipartUpload(testRepositories+"a/_uploads/m9/data", "new", now)
+
+	cl, out := newTestCleaner(t, f, Config{})
+	report := run(t, cl, out)
+
+	if report.MPUsAborted != 5 || report.FoldersRemoved != 4 || report.ErrorCount != 0 {
+		t.Errorf("aborted %d MPUs and removed %d folders with %d errors, want 5, 4 and 0\n%s",
+			report.MPUsAborted, report.FoldersRemoved, report.ErrorCount, out)
+	}
+	if got := f.keys(); !slices.Equal(got, fresh) {
+		t.Errorf("keys left %v, want %v", got, fresh)
+	}
+	if got := f.uploadIDs(); !slices.Equal(got, []string{"new"}) {
+		t.Errorf("uploads left %v, want [new]", got)
+	}
+
+	// Every listing took several pages.
+	pages := 0
+	for _, c := range f.callsOf("ListObjectsV2") {
+		if !strings.HasSuffix(c, "token=") {
+			pages++
+		}
+	}
+	if pages == 0 {
+		t.Error("no ListObjectsV2 call used a continuation token")
+	}
+	if n := len(f.callsOf("ListMultipartUploads")); n < 4 {
+		t.Errorf("%d ListMultipartUploads calls, want several pages for repository a", n)
+	}
+}
+
+func TestRunThresholdBoundary(t *testing.T) {
+	tests := []struct {
+		age     time.Duration
+		removed bool
+	}{
+		{11 * time.Hour, false},
+		{12*time.Hour + 30*time.Minute, false},
+		{13*time.Hour + time.Minute, true},
+		{48 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.age.String(), func(t *testing.T) {
+			started := time.Now().Add(-tt.age)
+			f := newFakeS3()
+			f.putUpload(testRepositories+"repo/_uploads/u/", started, 1)
+			f.putMultipartUpload(testRepositories+"repo/_uploads/u/data", "mpu", started)
+			// An active registry, so the activity check doesn't matter.
+			f.put(testRepositories+"repo/_manifests/tags/latest/current/link", []byte("sha256:x"), time.Now())
+
+			cl, out := newTestCleaner(t, f, Config{})
+			report := run(t, cl, out)
+
+			want := 0
+			if tt.removed {
+				want = 1
+			}
+			if report.MPUsAborted != want || report.FoldersRemoved != want {
+				t.Errorf("aborted %d MPUs and removed %d folders, want %d of each\n%s",
+					report.MPUsAborted, report.FoldersRemoved, want, out)
+			}
+		})
+	}
+}
+
+func TestDryRunLeavesBucketUntouched(t *testing.T) {
+	for _, detail := range []string{"summary", "keys"} {
+		t.Run(detail, func(t *testing.T) {
+			now := time.Now()
+			f := newFakeS3()
+			f.putUpload(testRepositories+"repo/_uploads/old/", now.Add(-48*time.Hour), 10)
+			f.put(testRepositories+"repo/_uploads/orphan/data", []byte("x"), now.Add(-48*time.Hour))
+			f.putMultipartUpload(testRepositories+"repo/_uploads/old/data", "mpu", now.Add(-48*time.Hour))
+			keys, uploads := f.keys(), f.uploadIDs()
+
+			cl, out := newTestCleaner(t, f, Config{DryRun: true, DryRunDetail: detail, CleanOrphans: true})
+			report := run(t, cl, out)
+
+			if report.MPUsAborted != 1 || report.FoldersRemoved != 2 {
+				t.Errorf("would abort %d MPUs and remove %d folders, want 1 and 2\n%s", report.MPUsAborted, report.FoldersRemoved, out)
+			}
+			if !slices.Equal(f.keys(), keys) || !slices.Equal(f.uploadIDs(), uploads) {
+				t.Errorf("dry run changed the bucket: keys %v, uploads %v", f.keys(), f.uploadIDs())
+			}
+			for _, op := range []string{"DeleteObjects", "DeleteObject", "AbortMultipartUpload", "PutObject", "CopyObject"} {
+				if calls := f.callsOf(op); len(calls) > 0 {
+					t.Errorf("dry run made %s calls: %v", op, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRunErrorInjection(t *testing.T) {
+	now := time.Now()
+	f := newFakeS3()
+	f.putUpload(testRepositories+"repo/_uploads/u1/", now.Add(-48*time.Hour), 1, 1)
+	f.putUpload(testRepositories+"repo/_uploads/u2/", now.Add(-48*time.Hour), 1)
+	f.putMultipartUpload(testRepositories+"repo/_uploads/m1/data", "m1", now.Add(-48*time.Hour))
+	f.putMultipartUpload(testRepositories+"repo/_uploads/m2/data", "m2", now.Add(-48*time.Hour))
+
+	denied := testRepositories + "repo/_uploads/u1/data1"
+	f.deleteErrs[denied] = []string{"AccessDenied"}
+	f.fail["AbortMultipartUpload"] = []error{nil, &smithy.GenericAPIError{Code: "AccessDenied"}}
+
+	cl, out := newTestCleaner(t, f, Config{})
+	report := run(t, cl, out)
+
+	if report.ErrorCount != 2 {
+		t.Errorf("%d errors, want 2 (one key, one abort)\n%s", report.ErrorCount, out)
+	}
+	if report.MPUsAborted != 1 || report.FoldersRemoved != 1 {
+		t.Errorf("aborted %d MPUs and removed %d folders, want 1 of each\n%s", report.MPUsAborted, report.FoldersRemoved, out)
+	}
+	if got := f.keys(); !slices.Equal(got, []string{denied}) {
+		t.Errorf("keys left %v, want only %s", got, denied)
+	}
+	if got := f.uploadIDs(); !slices.Equal(got, []string{"m2"}) {
+		t.Errorf("uploads left %v, want [m2]", got)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("partially removed, 1 objects left")) {
+		t.Errorf("u1 not reported as partially removed\n%s", out)
+	}
+}
+
+func TestRunListingFailure(t *testing.T) {
+	tests := []struct {
+		code    string
+		fatal   bool
+		removed int
+	}{
+		// Limited to the first bucket, the second one is still cleaned.
+		{"NoSuchBucket", false, 1},
+		{"AccessDenied", false, 1},
+		// Would fail the second bucket the same way.
+		{"InvalidAccessKeyId", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			f := newFakeS3()
+			f.putUpload(testRepositories+"repo/_uploads/u/", time.Now().Add(-48*time.Hour), 1)
+			f.fail["ListObjectsV2"] = []error{&smithy.GenericAPIError{Code: tt.code}}
+
+			cl, out := newTestCleaner(t, f, Config{Buckets: []string{"b1", "b2"}})
+			report, err := cl.Run(context.Background())
+
+			if (err != nil) != tt.fatal {
+				t.Errorf("Run returned %v, want a fatal error: %t\n%s", err, tt.fatal, out)
+			}
+			if report.FoldersRemoved != tt.removed || report.ErrorCount != 1 {
+				t.Errorf("removed %d folders with %d errors, want %d and 1\n%s",
+					report.FoldersRemoved, report.ErrorCount, tt.removed, out)
+			}
+		})
+	}
+
+	t.Run("every bucket", func(t *testing.T) {
+		f := newFakeS3()
+		denied := &smithy.GenericAPIError{Code: "AccessDenied"}
+		f.fail["ListObjectsV2"] = []error{denied, denied}
+
+		cl, out := newTestCleaner(t, f, Config{Buckets: []string{"b1", "b2"}})
+		if _, err := cl.Run(context.Background()); err == nil {
+			t.Errorf("Run returned no error with the listing of every bucket failing\n%s", out)
+		}
+	})
+}
+
+func TestRunVersionedDeletedFolders(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.versioned = true
+	deleted := func(folder string, at time.Time) {
+		f.putUpload(folder, old, 10)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, key := range f.sortedKeys(folder) {
+			f.putLocked(key, fakeVersion{deleteMarker: true, modified: at})
+		}
+	}
+	deleted(testRepositories+"repo/_uploads/done/", old)
+	deleted(testRepositories+"repo/_uploads/just-done/", time.Now())
+	f.putUpload(testRepositories+"repo/_uploads/stale/", old, 10)
+
+	// Without --clean-deleted-folders only the folder with current
+	// objects is found.
+	cl, out := newTestCleaner(t, f, Config{DryRun: true})
+	if report := run(t, cl, out); report.FoldersRemoved != 1 || len(f.callsOf("ListObjectVersions")) != 0 {
+		t.Errorf("dry run removes %d folders with %d ListObjectVersions calls, want 1 and none\n%s",
+			report.FoldersRemoved, len(f.callsOf("ListObjectVersions")), out)
+	}
+
+	cl, out = newTestCleaner(t, f, Config{CleanDeletedFolders: true})
+	report := run(t, cl, out)
+
+	if report.FoldersRemoved != 2 || report.ErrorCount != 0 {
+		t.Errorf("removed %d folders with %d errors, want 2 and 0\n%s", report.FoldersRemoved, report.ErrorCount, out)
+	}
+	if !strings.Contains(out.String(), "Removing 4 versions and delete markers of deleted folder "+testRepositories+"repo/_uploads/done/") {
+		t.Errorf("deleted folder not removed\n%s", out)
+	}
+	if !strings.Contains(out.String(), "Deleted upload folders, versions removed: 1") {
+		t.Errorf("deleted folder not counted\n%s", out)
+	}
+	if len(f.callsOf("ListObjectVersions")) == 0 {
+		t.Error("upload folders not listed with ListObjectVersions")
+	}
+
+	// Only the versions of the folder deleted just now are left.
+	var left []string
+	for key := range f.objects {
+		left = append(left, key)
+	}
+	slices.Sort(left)
+	want := []string{testRepositories + "repo/_uploads/just-done/data0", testRepositories + "repo/_uploads/just-done/startedat"}
+	if !slices.Equal(left, want) {
+		t.Errorf("keys with versions left %v, want %v", left, want)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+
+	t.Run("unrecognized startedat", func(t *testing.T) {
+		f := newFakeS3()
+		f.put(testRepositories+"repo/_uploads/a/startedat", []byte("garbage"), old)
+		f.putUpload(testRepositories+"repo/_uploads/b/", old, 1)
+
+		cl, out := newTestCleaner(t, f, Config{FailFast: true})
+		report := run(t, cl, out)
+		if report.FailedFast || report.ErrorCount != 1 || report.FoldersRemoved != 1 {
+			t.Errorf("failed fast %t, %d errors, %d folders removed, want false, 1 and 1\n%s",
+				report.FailedFast, report.ErrorCount, report.FoldersRemoved, out)
+		}
+	})
+
+	t.Run("failed delete", func(t *testing.T) {
+		f := newFakeS3()
+		f.putUpload(testRepositories+"repo/_uploads/a/", old, 1)
+		f.putUpload(testRepositories+"repo/_uploads/b/", old, 1)
+		f.deleteErrs[testRepositories+"repo/_uploads/a/data0"] = []string{"AccessDenied"}
+
+		cl, out := newTestCleaner(t, f, Config{FailFast: true})
+		report := run(t, cl, out)
+		if !report.FailedFast || report.ErrorCount != 1 || report.FoldersRemoved != 0 {
+			t.Errorf("failed fast %t, %d errors, %d folders removed, want true, 1 and 0\n%s",
+				report.FailedFast, report.ErrorCount, report.FoldersRemoved, out)
+		}
+	})
+}
+
+func TestDryRunBytesReclaimed(t *testing.T) {
+	for _, detail := range []string{"summary", "keys"} {
+		t.Run(detail, func(t *testing.T) {
+			old := time.Now().Add(-48 * time.Hour)
+			f := newFakeS3()
+			f.putUpload(testRepositories+"repo/_uploads/a/", old, 100, 200)
+			f.putUpload(testRepositories+"repo/_uploads/b/", old, 300)
+			f.putMultipartUpload(testRepositories+"repo/_uploads/a/data", "mpu", old)
+
+			cl, out := newTestCleaner(t, f, Config{DryRun: true, DryRunDetail: detail})
+			report := run(t, cl, out)
+
+			// The objects of both folders, startedat included.
+			startedat := int64(len(old.UTC().Format(time.RFC3339)))
+			want := 600 + 2*startedat
+			if report.BytesReclaimed != want || report.UnsizedMPUs != 1 {
+				t.Errorf("would reclaim %d bytes with %d unsized MPUs, want %d and 1\n%s", report.BytesReclaimed, report.UnsizedMPUs, want, out)
+			}
+			line := fmt.Sprintf("Bytes that would be reclaimed: %d, plus 1 multipart uploads of unknown size", want)
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("summary without %q\n%s", line, out)
+			}
+		})
+	}
+}
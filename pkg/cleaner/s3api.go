@@ -0,0 +1,121 @@
+package cleaner
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3API is the part of the S3 API the cleanup uses, implemented by
+// *s3.Client. Keeping it small makes it possible to run the cleanup against
+// an in-memory implementation.
+type S3API interface {
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+
+	ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
+	ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+var _ S3API = (*s3.Client)(nil)
+
+// countingClient counts the requests made through it, in total for the
+// progress line and by operation.
+type countingClient struct {
+	S3API
+	calls *atomic.Int64
+	stats *apiStats
+}
+
+func (c countingClient) count(op string, err error) {
+	c.calls.Add(1)
+	c.stats.call(op, err)
+}
+
+func (c countingClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	out, err := c.S3API.ListObjectsV2(ctx, params, optFns...)
+	c.count("ListObjectsV2", err)
+	return out, err
+}
+
+func (c countingClient) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	out, err := c.S3API.ListObjectVersions(ctx, params, optFns...)
+	c.count("ListObjectVersions", err)
+	return out, err
+}
+
+func (c countingClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	out, err := c.S3API.GetObject(ctx, params, optFns...)
+	c.count("GetObject", err)
+	return out, err
+}
+
+func (c countingClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	out, err := c.S3API.HeadObject(ctx, params, optFns...)
+	c.count("HeadObject", err)
+	return out, err
+}
+
+func (c countingClient) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	out, err := c.S3API.CopyObject(ctx, params, optFns...)
+	c.count("CopyObject", err)
+	return out, err
+}
+
+func (c countingClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	out, err := c.S3API.PutObject(ctx, params, optFns...)
+	c.count("PutObject", err)
+	return out, err
+}
+
+func (c countingClient) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	out, err := c.S3API.DeleteObject(ctx, params, optFns...)
+	c.count("DeleteObject", err)
+	return out, err
+}
+
+func (c countingClient) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	out, err := c.S3API.DeleteObjects(ctx, params, optFns...)
+	c.count("DeleteObjects", err)
+	return out, err
+}
+
+func (c countingClient) GetBucketVersioning(ctx context.Context, params *s3.GetBucketVersioningInput, optFns ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	out, err := c.S3API.GetBucketVersioning(ctx, params, optFns...)
+	c.count("GetBucketVersioning", err)
+	return out, err
+}
+
+func (c countingClient) HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	out, err := c.S3API.HeadBucket(ctx, params, optFns...)
+	c.count("HeadBucket", err)
+	return out, err
+}
+
+func (c countingClient) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	out, err := c.S3API.ListMultipartUploads(ctx, params, optFns...)
+	c.count("ListMultipartUploads", err)
+	return out, err
+}
+
+func (c countingClient) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	out, err := c.S3API.ListParts(ctx, params, optFns...)
+	c.count("ListParts", err)
+	return out, err
+}
+
+func (c countingClient) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	out, err := c.S3API.AbortMultipartUpload(ctx, params, optFns...)
+	c.count("AbortMultipartUpload", err)
+	return out, err
+}
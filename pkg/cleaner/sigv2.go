@@ -0,0 +1,142 @@
+package cleaner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Query parameters that are part of the resource signed with Signature
+// Version 2, all others are left out.
+var sigV2SubResources = map[string]bool{
+	"acl": true, "cors": true, "delete": true, "lifecycle": true, "location": true,
+	"logging": true, "notification": true, "partNumber": true, "policy": true,
+	"requestPayment": true, "restore": true, "tagging": true, "torrent": true,
+	"uploadId": true, "uploads": true, "versionId": true, "versioning": true,
+	"versions": true, "website": true,
+	"response-cache-control": true, "response-content-disposition": true,
+	"response-content-encoding": true, "response-content-language": true,
+	"response-content-type": true, "response-expires": true,
+}
+
+// signV2 replaces the Signature Version 4 signing step of the S3 client,
+// for old Ceph RGW and other backends only supporting Signature Version 2.
+// The resource is signed as the request path, so it only works with path
+// style addressing.
+type signV2 struct {
+	credentials aws.CredentialsProvider
+}
+
+// otherSignatureVersion returns the signature version not in use, for
+// hints about signature errors.
+func (cl *Cleaner) otherSignatureVersion() string {
+	if cl.cfg.SignatureVersion == "v2" {
+		return "v4"
+	}
+	return "v2"
+}
+
+// useSignatureV2 makes the client sign its requests with signV2.
+func useSignatureV2(o *s3.Options) {
+	credentials := o.Credentials
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		_, err := stack.Finalize.Swap("Signing", &signV2{credentials: credentials})
+		return err
+	})
+}
+
+func (*signV2) ID() string { return "Signing" }
+
+func (s *signV2) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (
+	middleware.FinalizeOutput, middleware.Metadata, error,
+) {
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected request type %T", in.Request)
+	}
+
+	creds, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("retrieving credentials: %w", err)
+	}
+
+	req.Header.Del("X-Amz-Date")
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	signRequestV2(req.Request, creds)
+	return next.HandleFinalize(ctx, in)
+}
+
+// signRequestV2 sets the Authorization header of req, signed with creds.
+func signRequestV2(req *http.Request, creds aws.Credentials) {
+	mac := hmac.New(sha1.New, []byte(creds.SecretAccessKey))
+	mac.Write([]byte(stringToSignV2(req)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "AWS "+creds.AccessKeyID+":"+signature)
+}
+
+// stringToSignV2 returns the string signed for req, see "Signing and
+// authenticating REST requests" in the S3 documentation.
+func stringToSignV2(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	b.WriteString(req.Header.Get("Content-MD5") + "\n")
+	b.WriteString(req.Header.Get("Content-Type") + "\n")
+	b.WriteString(req.Header.Get("Date") + "\n")
+
+	var amzHeaders []string
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+		amzHeaders = append(amzHeaders, name+":"+strings.Join(trimmed, ","))
+	}
+	sort.Strings(amzHeaders)
+	for _, h := range amzHeaders {
+		b.WriteString(h + "\n")
+	}
+
+	b.WriteString(req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	var names []string
+	for name := range query {
+		if sigV2SubResources[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+		b.WriteString(name)
+		if value := query.Get(name); value != "" {
+			b.WriteString("=" + value)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,315 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+)
+
+// FormatBytes formats n with binary units, e.g. 38.2 GiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// runSummary collects the outcome of the cleanup of a bucket for the summary
+// printed at the end, including the partial summary printed when the run is
+// cut short.
+type runSummary struct {
+	bucket         string
+	rootDir        string
+	mpusRemoved    int
+	mpusFiltered   int
+	mpusSmall      int
+	mpusGone       int
+	foldersRemoved int
+	orphansRemoved int
+	foldersPartial int
+	emptyRemoved   int
+	deletedRemoved int
+	futureDated    int
+	activeSkipped  int
+	bytesReclaimed int64
+
+	// Multipart uploads a dry run would abort without knowing their size,
+	// which bytesReclaimed doesn't include.
+	unsized int
+
+	// Directory placeholders removed, counted apart from the objects.
+	placeholdersRemoved int
+
+	// Folders whose startedat was listed, but gone when it was read.
+	startedatNotFound int
+
+	// Sizes of what would be removed, added up with --estimate.
+	estimatedMPUBytes    int64
+	estimatedFolderBytes int64
+
+	versioned   bool
+	undeletable []undeletableKey
+	activity    registryActivity
+	errs        []error
+	stoppedAt   string
+	stopCause   error
+
+	// Multipart uploads and folders removed, or that would be removed in
+	// a dry run.
+	removed []candidate
+}
+
+// record adds c to the CSV report, and to the removed candidates of r if it
+// was (or would be) removed.
+func (cl *Cleaner) record(r *runSummary, c candidate) {
+	cl.candidatesCSV.add(c)
+
+	// Nothing is deleted in a dry run, what would be reclaimed is the size
+	// of the candidates: the objects listed in upload folders, the parts
+	// of multipart uploads when they were looked up.
+	if c.action == actionWouldRemove {
+		if c.size >= 0 {
+			r.bytesReclaimed += c.size
+		} else {
+			r.unsized++
+		}
+	}
+
+	if c.action == actionRemoved || c.action == actionWouldRemove {
+		r.removed = append(r.removed, c)
+		if c.kind == "mpu" {
+			cl.progress.mpusAborted.Add(1)
+		} else {
+			cl.progress.foldersRemoved.Add(1)
+		}
+	}
+
+	if cl.cfg.Estimate && c.action == actionWouldRemove && c.size > 0 {
+		if c.kind == "mpu" {
+			r.estimatedMPUBytes += c.size
+		} else {
+			r.estimatedFolderBytes += c.size
+		}
+	}
+}
+
+// label names the bucket and root directory the summary is about.
+func (cl *Cleaner) label(r *runSummary) string {
+	if len(cl.cfg.RootDirs) == 0 {
+		return "bucket " + r.bucket
+	}
+	return fmt.Sprintf("bucket %s, root directory %s", r.bucket, rootDirLabels([]string{r.rootDir})[0])
+}
+
+// printSummary prints the summary, labelled with the bucket and root directory
+// when several are cleaned in the same run.
+func (cl *Cleaner) printSummary(r *runSummary, labelled bool) {
+	cl.println()
+	if labelled {
+		cl.printf("Summary for %s:\n", cl.label(r))
+	} else {
+		cl.println("Summary:")
+	}
+	if r.stoppedAt != "" {
+		switch {
+		case errors.Is(r.stopCause, context.DeadlineExceeded):
+			cl.printf("  Run timed out after %s, processing stopped at %s\n", cl.cfg.Timeout, r.stoppedAt)
+		case errors.Is(r.stopCause, errFailFast):
+			cl.printf("  Run stopped at the first error (--fail-fast), processing stopped at %s\n", r.stoppedAt)
+		case errors.Is(r.stopCause, errAuditLog):
+			cl.printf("  Run stopped, the audit log can't be written, processing stopped at %s\n", r.stoppedAt)
+		default:
+			cl.printf("  Run interrupted, processing stopped at %s\n", r.stoppedAt)
+		}
+	}
+	cl.printf("  MPUs removed: %d\n", r.mpusRemoved)
+	if cl.initiatorFilter != nil {
+		cl.printf("  MPUs skipped by initiator filter: %d\n", r.mpusFiltered)
+	}
+	if cl.cfg.MinSize > 0 {
+		cl.printf("  MPUs skipped below size threshold: %d\n", r.mpusSmall)
+	}
+	if cl.cfg.AbortList != "" {
+		cl.printf("  MPUs already gone: %d\n", r.mpusGone)
+	}
+	if cl.cfg.Prefix == nil && cl.cfg.AbortList == "" {
+		cl.printf("  Upload folders removed: %d\n", r.foldersRemoved)
+		if cl.cfg.CleanOrphans {
+			cl.printf("  Orphan upload folders removed: %d\n", r.orphansRemoved)
+		}
+		if r.foldersPartial > 0 {
+			cl.printf("  Upload folders partially removed: %d\n", r.foldersPartial)
+		}
+		if cl.cfg.PruneEmptyUploads {
+			cl.printf("  Empty upload folders removed: %d\n", r.emptyRemoved)
+		}
+		if r.versioned && cl.cfg.CleanDeletedFolders {
+			cl.printf("  Deleted upload folders, versions removed: %d\n", r.deletedRemoved)
+		}
+		if r.placeholdersRemoved > 0 || cl.cfg.PruneEmptyUploads {
+			cl.printf("  Directory placeholders removed: %d\n", r.placeholdersRemoved)
+		}
+		if r.futureDated > 0 {
+			cl.printf("  Future-dated startedat: %d\n", r.futureDated)
+		}
+		if r.startedatNotFound > 0 {
+			cl.printf("  startedat not found, handled as orphans: %d\n", r.startedatNotFound)
+		}
+		if cl.cfg.IdleThreshold > 0 {
+			cl.printf("  Upload folders skipped as recently active: %d\n", r.activeSkipped)
+		}
+		cl.printBytesReclaimed(r)
+		cl.printf("  Registry activity: %s\n", r.activity.describe(cl.cfg.InactiveDays))
+		if r.versioned {
+			cl.println("  Versioned bucket: all versions and delete markers removed")
+		}
+		cl.printf("  Undeletable keys: %d\n", len(r.undeletable))
+	}
+	if cl.cfg.Estimate {
+		cl.printEstimate(r)
+	}
+	if len(r.errs) > 0 {
+		cl.printf("  Errors: %d\n", len(r.errs))
+	}
+
+	if len(r.undeletable) > 0 {
+		cl.println()
+		cl.println("Undeletable keys (rejected by the backend, remove them manually):")
+		for _, u := range r.undeletable {
+			cl.printf("  %s\n", u.Key)
+			cl.printf("    %s: %s\n", u.Code, u.Message)
+		}
+	}
+}
+
+// printTotals prints the grand total over all buckets and root directories
+// when more than one was cleaned, and returns the number of them with
+// errors.
+func (cl *Cleaner) printTotals(summaries []*runSummary) (failed int) {
+	total := runSummary{}
+	for _, r := range summaries {
+		total.mpusRemoved += r.mpusRemoved
+		total.mpusFiltered += r.mpusFiltered
+		total.mpusSmall += r.mpusSmall
+		total.foldersRemoved += r.foldersRemoved
+		total.orphansRemoved += r.orphansRemoved
+		total.foldersPartial += r.foldersPartial
+		total.emptyRemoved += r.emptyRemoved
+		total.deletedRemoved += r.deletedRemoved
+		total.versioned = total.versioned || r.versioned
+		total.placeholdersRemoved += r.placeholdersRemoved
+		total.futureDated += r.futureDated
+		total.activeSkipped += r.activeSkipped
+		total.startedatNotFound += r.startedatNotFound
+		total.bytesReclaimed += r.bytesReclaimed
+		total.unsized += r.unsized
+		total.estimatedMPUBytes += r.estimatedMPUBytes
+		total.estimatedFolderBytes += r.estimatedFolderBytes
+		total.undeletable = append(total.undeletable, r.undeletable...)
+		if len(r.errs) > 0 {
+			failed++
+		}
+	}
+
+	if len(summaries) > 1 {
+		cl.printf("Total for %d buckets/root directories:\n", len(summaries))
+		cl.printf("  MPUs removed: %d\n", total.mpusRemoved)
+		if cl.initiatorFilter != nil {
+			cl.printf("  MPUs skipped by initiator filter: %d\n", total.mpusFiltered)
+		}
+		if cl.cfg.MinSize > 0 {
+			cl.printf("  MPUs skipped below size threshold: %d\n", total.mpusSmall)
+		}
+		cl.printf("  Upload folders removed: %d\n", total.foldersRemoved)
+		if cl.cfg.CleanOrphans {
+			cl.printf("  Orphan upload folders removed: %d\n", total.orphansRemoved)
+		}
+		if total.foldersPartial > 0 {
+			cl.printf("  Upload folders partially removed: %d\n", total.foldersPartial)
+		}
+		if cl.cfg.PruneEmptyUploads {
+			cl.printf("  Empty upload folders removed: %d\n", total.emptyRemoved)
+		}
+		if total.versioned && cl.cfg.CleanDeletedFolders {
+			cl.printf("  Deleted upload folders, versions removed: %d\n", total.deletedRemoved)
+		}
+		if total.placeholdersRemoved > 0 || cl.cfg.PruneEmptyUploads {
+			cl.printf("  Directory placeholders removed: %d\n", total.placeholdersRemoved)
+		}
+		if total.futureDated > 0 {
+			cl.printf("  Future-dated startedat: %d\n", total.futureDated)
+		}
+		if total.startedatNotFound > 0 {
+			cl.printf("  startedat not found, handled as orphans: %d\n", total.startedatNotFound)
+		}
+		if cl.cfg.IdleThreshold > 0 {
+			cl.printf("  Upload folders skipped as recently active: %d\n", total.activeSkipped)
+		}
+		cl.printBytesReclaimed(&total)
+		cl.printf("  Undeletable keys: %d\n", len(total.undeletable))
+		if cl.cfg.Estimate {
+			cl.printEstimate(&total)
+		}
+		cl.printf("  With errors: %d\n", failed)
+	}
+
+	return
+}
+
+// printRepositories prints the repositories most of the removed multipart
+// uploads and folders came from.
+func (cl *Cleaner) printRepositories(summaries []*runSummary) {
+	if cl.cfg.Prefix != nil {
+		return
+	}
+
+	totals := cl.topRepositories(summaries)
+	if len(totals) == 0 {
+		return
+	}
+
+	cl.println()
+	if cl.cfg.Top > 0 {
+		cl.printf("Top %d repositories:\n", cl.cfg.Top)
+	} else {
+		cl.println("Repositories:")
+	}
+
+	w := tabwriter.NewWriter(cl.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  Repository\tMPUs\tFolders\tReclaimed")
+	for _, t := range totals {
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s\n", t.name, t.mpus, t.folders, FormatBytes(t.bytes))
+	}
+	w.Flush()
+}
+
+// printBytesReclaimed prints the bytes reclaimed, or in a dry run the bytes
+// that would be, with the multipart uploads whose size isn't known.
+func (cl *Cleaner) printBytesReclaimed(r *runSummary) {
+	if !cl.cfg.DryRun {
+		cl.printf("  Bytes reclaimed: %d\n", r.bytesReclaimed)
+		return
+	}
+	if r.unsized > 0 {
+		cl.printf("  Bytes that would be reclaimed: %d, plus %d multipart uploads of unknown size (--estimate adds them up)\n", r.bytesReclaimed, r.unsized)
+		return
+	}
+	cl.printf("  Bytes that would be reclaimed: %d\n", r.bytesReclaimed)
+}
+
+// printEstimate prints the space --estimate found would be reclaimed.
+func (cl *Cleaner) printEstimate(r *runSummary) {
+	cl.printf("  Estimated reclaimable, multipart uploads: %s\n", FormatBytes(r.estimatedMPUBytes))
+	if cl.cfg.Prefix == nil {
+		cl.printf("  Estimated reclaimable, upload folders: %s\n", FormatBytes(r.estimatedFolderBytes))
+	}
+	cl.printf("  Estimated reclaimable, total: %s\n", FormatBytes(r.estimatedMPUBytes+r.estimatedFolderBytes))
+}
@@ -0,0 +1,188 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// thresholdRule is a --threshold override of the cleanup threshold for the
+// repositories matching pattern, in bucket or in every bucket when bucket
+// is "".
+type thresholdRule struct {
+	spec      string
+	bucket    string
+	pattern   string
+	threshold time.Duration
+}
+
+// parseThresholdRule parses a --threshold value, [bucket:]pattern=duration.
+func parseThresholdRule(spec string) (thresholdRule, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return thresholdRule{}, fmt.Errorf("invalid --threshold %q: expected [bucket:]pattern=duration", spec)
+	}
+
+	r := thresholdRule{spec: spec, pattern: spec[:i]}
+	if bucket, pattern, ok := strings.Cut(r.pattern, ":"); ok {
+		r.bucket, r.pattern = bucket, pattern
+	}
+	r.pattern = strings.Trim(r.pattern, "/")
+	if r.pattern == "" {
+		return thresholdRule{}, fmt.Errorf("invalid --threshold %q: no repository pattern", spec)
+	}
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		return thresholdRule{}, fmt.Errorf("invalid --threshold %q: %w", spec, err)
+	}
+
+	var err error
+	if r.threshold, err = time.ParseDuration(spec[i+1:]); err != nil {
+		return thresholdRule{}, fmt.Errorf("invalid --threshold %q: %w", spec, err)
+	}
+	if r.threshold <= 0 {
+		return thresholdRule{}, fmt.Errorf("invalid --threshold %q: the threshold must be positive", spec)
+	}
+	return r, nil
+}
+
+// matches reports whether r applies to repository in bucket. A pattern
+// matching a parent of the repository applies to it too, so mlteam/*
+// covers mlteam/models/llm.
+func (r thresholdRule) matches(bucket, repository string) bool {
+	if repository == "" || r.bucket != "" && r.bucket != bucket {
+		return false
+	}
+	for name := repository; ; {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// specificity ranks the rules matching the same repository: the pattern
+// with the most literal characters wins, and of two equal ones the rule
+// naming the bucket.
+func (r thresholdRule) specificity() int {
+	n := 0
+	for _, c := range r.pattern {
+		if !strings.ContainsRune(`*?[]\`, c) {
+			n++
+		}
+	}
+	n *= 2
+	if r.bucket != "" {
+		n++
+	}
+	return n
+}
+
+// thresholdRule returns the --threshold rule applying to key in bucket, nil
+// when the cleanup threshold applies. Of equally specific rules the first
+// one given wins.
+func (cl *Cleaner) thresholdRule(bucket, key string) *thresholdRule {
+	repository := repositoryName(key)
+
+	var best *thresholdRule
+	for i := range cl.thresholds {
+		r := &cl.thresholds[i]
+		if r.matches(bucket, repository) && (best == nil || r.specificity() > best.specificity()) {
+			best = r
+		}
+	}
+	return best
+}
+
+// thresholdNote returns which threshold the age of key was compared to, for
+// the log lines of the decision, or "" without --threshold rules.
+func (cl *Cleaner) thresholdNote(bucket, key string) string {
+	if len(cl.thresholds) == 0 {
+		return ""
+	}
+	if r := cl.thresholdRule(bucket, key); r != nil {
+		return fmt.Sprintf(", threshold %s from --threshold %s", r.threshold, r.spec)
+	}
+	return fmt.Sprintf(", threshold %s (default)", cl.describeThreshold())
+}
+
+// warnUnmatchedThresholds warns, before anything is removed, about the
+// --threshold rules whose pattern can't match any of the repositories of a
+// bucket, judged by their first path element. These are usually
+// misspelled. Rules naming a bucket the run doesn't clean are warned about
+// by New.
+func (cl *Cleaner) warnUnmatchedThresholds(ctx context.Context) {
+	if len(cl.thresholds) == 0 || cl.cfg.Prefix != nil || cl.cfg.AbortList != "" {
+		return
+	}
+
+	tops := map[string][]string{}
+	for _, bucket := range cl.buckets {
+		for _, rootDir := range cl.rootDirs {
+			names, err := cl.topRepositoryNames(ctx, bucket, repositoriesPath(rootDir))
+			if err != nil {
+				// The run reports the failed listing.
+				return
+			}
+			tops[bucket] = append(tops[bucket], names...)
+		}
+	}
+
+	for _, r := range cl.thresholds {
+		if r.bucket != "" && !slices.Contains(cl.buckets, r.bucket) {
+			continue
+		}
+
+		first, _, _ := strings.Cut(r.pattern, "/")
+		for _, bucket := range cl.buckets {
+			if r.bucket != "" && r.bucket != bucket {
+				continue
+			}
+			found := slices.ContainsFunc(tops[bucket], func(top string) bool {
+				ok, _ := path.Match(first, top)
+				return ok
+			})
+			if !found {
+				cl.printf("WARNING: --threshold %s matches no repository in bucket %s\n", r.spec, bucket)
+			}
+		}
+	}
+}
+
+// topRepositoryNames returns the first path elements of the repositories
+// below prefix, those of the --repository prefixes when given.
+func (cl *Cleaner) topRepositoryNames(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var names []string
+	if len(cl.repositories) > 0 {
+		for _, r := range cl.repositories {
+			top, _, _ := strings.Cut(r, "/")
+			names = append(names, top)
+		}
+		return names, nil
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(cl.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range page.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(*cp.Prefix, prefix), "/"))
+		}
+	}
+	return names, nil
+}
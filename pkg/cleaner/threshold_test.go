@@ -0,0 +1,115 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseThresholdRule(t *testing.T) {
+	tests := []struct {
+		spec      string
+		bucket    string
+		pattern   string
+		threshold time.Duration
+		wantErr   bool
+	}{
+		{"mlteam/*=24h", "", "mlteam/*", 24 * time.Hour, false},
+		{"mirrors=30m", "", "mirrors", 30 * time.Minute, false},
+		{"prod:mlteam/*=24h", "prod", "mlteam/*", 24 * time.Hour, false},
+		{"/mlteam/models/=2h", "", "mlteam/models", 2 * time.Hour, false},
+		{"mlteam/*", "", "", 0, true},
+		{"=24h", "", "", 0, true},
+		{"prod:=24h", "", "", 0, true},
+		{"[mlteam=24h", "", "", 0, true},
+		{"mlteam=a day", "", "", 0, true},
+		{"mlteam=0s", "", "", 0, true},
+		{"mlteam=-1h", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		r, err := parseThresholdRule(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseThresholdRule(%q) error %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if r.bucket != tt.bucket || r.pattern != tt.pattern || r.threshold != tt.threshold {
+			t.Errorf("parseThresholdRule(%q) = bucket %q, pattern %q, %s, want %q, %q, %s",
+				tt.spec, r.bucket, r.pattern, r.threshold, tt.bucket, tt.pattern, tt.threshold)
+		}
+	}
+}
+
+func TestThresholdRuleRanking(t *testing.T) {
+	f := newFakeS3()
+	cl, _ := newTestCleaner(t, f, Config{Thresholds: []string{
+		"mlteam/*=24h",
+		"mlteam/models/llm=48h",
+		"prod:mlteam/*=36h",
+		"mirrors/*=1h",
+		"mirrors/*=2h",
+		"*/base=3h",
+	}})
+
+	tests := []struct {
+		bucket, repository string
+		want               string
+	}{
+		{"bucket", "mlteam/models/llm", "mlteam/models/llm=48h"},
+		{"bucket", "mlteam/models/llm/v2", "mlteam/models/llm=48h"},
+		{"bucket", "mlteam/models/vision", "mlteam/*=24h"},
+		{"bucket", "mlteam/train", "mlteam/*=24h"},
+		// Of equally specific patterns the one naming the bucket wins.
+		{"prod", "mlteam/train", "prod:mlteam/*=36h"},
+		{"prod", "mlteam/models/llm", "mlteam/models/llm=48h"},
+		// Of equal rules the first one given.
+		{"bucket", "mirrors/alpine", "mirrors/*=1h"},
+		{"bucket", "library/base", "*/base=3h"},
+		{"bucket", "library/alpine", ""},
+		{"bucket", "mlteam", ""},
+	}
+
+	for _, tt := range tests {
+		key := testRepositories + tt.repository + "/_uploads/u/startedat"
+		got := ""
+		if r := cl.thresholdRule(tt.bucket, key); r != nil {
+			got = r.spec
+		}
+		if got != tt.want {
+			t.Errorf("rule of %s in %s = %q, want %q", tt.repository, tt.bucket, got, tt.want)
+		}
+	}
+}
+
+func TestUnmatchedThresholdsWarnedAtStart(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	f := newFakeS3()
+	f.putUpload(testRepositories+"mlteam/models/_uploads/a/", old, 1)
+	f.putUpload(testRepositories+"library/alpine/_uploads/b/", old, 1)
+
+	cl, out := newTestCleaner(t, f, Config{Thresholds: []string{
+		"mlteam/*=24h",
+		"mlteem/*=24h",
+		"other:library/*=24h",
+		"bucket:lib*/alpine=24h",
+	}})
+	run(t, cl, out)
+
+	output := out.String()
+	removing := strings.Index(output, "Removing")
+	if removing < 0 {
+		t.Fatalf("nothing removed\n%s", output)
+	}
+	for _, want := range []string{
+		"WARNING: --threshold mlteem/*=24h matches no repository in bucket bucket",
+		"WARNING: --threshold other:library/*=24h is for bucket other, which isn't cleaned",
+	} {
+		i := strings.Index(output, want)
+		if i < 0 || i > removing {
+			t.Errorf("%q not printed before the removals\n%s", want, output)
+		}
+	}
+	if strings.Count(output, "WARNING: --threshold") != 2 {
+		t.Errorf("warnings about the matching rules\n%s", output)
+	}
+}
@@ -0,0 +1,145 @@
+package cleaner
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tlsConfig returns the TLS configuration for the S3 endpoint from --ca-cert,
+// --insecure and the client certificate flags, or nil to use the system
+// defaults.
+func (cl *Cleaner) tlsConfig() (*tls.Config, error) {
+	if cl.cfg.CACert != "" && cl.cfg.Insecure {
+		return nil, errors.New("--ca-cert and --insecure are mutually exclusive")
+	}
+	if (cl.cfg.ClientCert == "") != (cl.cfg.ClientKey == "") {
+		return nil, errors.New("--client-cert and --client-key must be given together")
+	}
+
+	if !cl.cfg.Insecure && cl.cfg.CACert == "" && cl.cfg.ClientCert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	switch {
+	case cl.cfg.Insecure:
+		cl.println("WARNING: --insecure disables verification of the endpoint's certificate")
+		config.InsecureSkipVerify = true
+	case cl.cfg.CACert != "":
+		pool, err := loadCertPool(cl.cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("--ca-cert %s: %w", cl.cfg.CACert, err)
+		}
+		config.RootCAs = pool
+	}
+
+	if cl.cfg.ClientCert != "" {
+		cert, err := loadClientCertificate(cl.cfg.ClientCert, cl.cfg.ClientKey, cl.cfg.ClientKeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("--client-cert %s: %w", cl.cfg.ClientCert, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// loadClientCertificate loads the key pair in certFile and keyFile. Keys in
+// the legacy encrypted PEM format are decrypted with password.
+func loadClientCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("%s: no PEM private key found", keyFile)
+	}
+
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		return tls.Certificate{}, fmt.Errorf("%s: encrypted PKCS#8 keys aren't supported, convert it with openssl", keyFile)
+	case x509.IsEncryptedPEMBlock(block):
+		if password == "" {
+			return tls.Certificate{}, fmt.Errorf("%s is encrypted, use --client-key-password", keyFile)
+		}
+
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("decrypting %s: %w", keyFile, err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// checkClientCertificate makes a request to endpoint so a client certificate
+// the endpoint rejects is reported before the cleanup starts. Any HTTP
+// response means the handshake succeeded.
+func checkClientCertificate(ctx context.Context, client s3.HTTPClient, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "remote error: tls") {
+			return fmt.Errorf("client certificate rejected by %s: %w", endpoint, err)
+		}
+		return fmt.Errorf("connecting to %s: %w", endpoint, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// loadCertPool reads every PEM certificate in path into a new pool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	found := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", found+1, err)
+		}
+		pool.AddCert(cert)
+		found++
+	}
+
+	if found == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return pool, nil
+}
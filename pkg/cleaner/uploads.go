@@ -0,0 +1,331 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const uploadsDir = "/_uploads/"
+
+// uploadFolder is an _uploads/<id>/ folder of a repository, built from the
+// objects listed below it.
+type uploadFolder struct {
+	path              string
+	startedat         string
+	startedatModified time.Time
+	newest            time.Time
+	size              int64
+
+	// Number of real objects and of directory placeholders listed.
+	objects      int
+	placeholders int
+
+	// Number of noncurrent versions and delete markers listed in a
+	// versioned bucket.
+	versions int
+}
+
+// uploadFolderPath returns the _uploads/<id>/ folder key belongs to, or ""
+// when key isn't inside an upload folder.
+func uploadFolderPath(key string) string {
+	i := strings.Index(key, uploadsDir)
+	if i < 0 {
+		return ""
+	}
+
+	start := i + len(uploadsDir)
+	end := strings.Index(key[start:], "/")
+	if end <= 0 {
+		return ""
+	}
+
+	return key[:start+end+1]
+}
+
+func (f *uploadFolder) add(o types.Object) {
+	if *o.Key == f.path+"startedat" {
+		f.startedat = *o.Key
+		if o.LastModified != nil {
+			f.startedatModified = *o.LastModified
+		}
+	}
+
+	if o.LastModified != nil && o.LastModified.After(f.newest) {
+		f.newest = *o.LastModified
+	}
+
+	f.size += aws.ToInt64(o.Size)
+	if aws.ToInt64(o.Size) == 0 && strings.HasSuffix(*o.Key, "/") {
+		f.placeholders++
+	} else {
+		f.objects++
+	}
+}
+
+// addEntry adds a listed object, or a version or delete marker of one.
+// Only current objects count for the startedat file and the objects of the
+// folder, every version adds to its size.
+func (f *uploadFolder) addEntry(e folderEntry) {
+	if e.current {
+		f.add(e.Object)
+		return
+	}
+
+	f.versions++
+	f.size += aws.ToInt64(e.Size)
+	if e.LastModified != nil && e.LastModified.After(f.newest) {
+		f.newest = *e.LastModified
+	}
+}
+
+// candidate returns the report entry for the folder, before its age is
+// known.
+func (f *uploadFolder) candidate(bucket, kind string) candidate {
+	return candidate{
+		kind:   kind,
+		bucket: bucket,
+		key:    f.path,
+		size:   f.size,
+		action: actionSkipped,
+	}
+}
+
+// uploadAge returns when the upload of folder was started, and whether that
+// came from the content of startedat or its LastModified time, as chosen by
+// TimeSource. With the default, "startedat", and --fallback-lastmodified,
+// LastModified is used when startedat can't be read, and when both are
+// known the more recent one wins so the folder never looks older than it
+// is. The other time sources always fall back to LastModified. When both
+// times are known and further apart than TimeSkew, both are printed.
+func (cl *Cleaner) uploadAge(ctx context.Context, bucket string, folder *uploadFolder) (time.Time, string, error) {
+	started, err := cl.uploadStartedAt(ctx, bucket, folder.startedat)
+	modified := folder.startedatModified
+
+	source := cl.cfg.TimeSource
+	if source == "startedat" && cl.cfg.FallbackLastModified {
+		source = "newest"
+	}
+	if modified.IsZero() || ctx.Err() != nil || isNotFound(err) {
+		return started, "startedat", err
+	}
+
+	if err != nil {
+		if source == "startedat" {
+			return started, "startedat", err
+		}
+		if source != "s3" {
+			cl.printf("  WARNING: %s, falling back to LastModified\n", err)
+		}
+		return modified, "LastModified", nil
+	}
+
+	if skew := started.Sub(modified).Abs(); cl.cfg.TimeSkew > 0 && skew > cl.cfg.TimeSkew {
+		cl.printf("  WARNING: startedat %s says %s, its LastModified is %s (%s apart), check the clock of the registry hosts\n",
+			folder.startedat, started.Format(time.RFC3339), modified.Format(time.RFC3339), skew.Round(time.Second))
+	}
+
+	switch {
+	case source == "s3",
+		source == "newest" && modified.After(started),
+		source == "oldest" && modified.Before(started):
+		return modified, "LastModified", nil
+	}
+	return started, "startedat", nil
+}
+
+// cleanOrphanFolder handles upload folders without a startedat file, left
+// behind by registry crashes. With --clean-orphans they are removed once
+// their newest object is older than the cleanup threshold.
+func (cl *Cleaner) cleanOrphanFolder(ctx context.Context, summary *runSummary, folder *uploadFolder) {
+	if !cl.cfg.CleanOrphans {
+		return
+	}
+
+	hoursSince := int(time.Since(folder.newest).Hours())
+	age := fmt.Sprintf("%d hours since last modified%s", hoursSince, cl.thresholdNote(summary.bucket, folder.path))
+
+	c := folder.candidate(summary.bucket, "orphan-folder")
+	c.started, c.hours = folder.newest, hoursSince
+	defer func() { cl.record(summary, c) }()
+
+	if !cl.stale(folder.newest, summary.bucket, folder.path) {
+		cl.printf("  Skipping orphan (no startedat) folder %s (%s)\n", folder.path, age)
+		return
+	}
+
+	if cl.cfg.DryRun {
+		cl.printf("  Would remove orphan (no startedat) folder %s (%s)\n", folder.path, age)
+		summary.placeholdersRemoved += folder.placeholders
+		if cl.cfg.DryRunDetail == "keys" {
+			size, err := cl.previewFolder(ctx, summary, folder.path)
+			if err != nil {
+				cl.printf(" ERROR: %s\n", err)
+				cl.addError(summary, err)
+				c.action = actionError
+				return
+			}
+			c.size = size
+		}
+		c.action = actionWouldRemove
+	} else {
+		cl.printf("  Removing orphan (no startedat) folder %s (%s)\n", folder.path, age)
+		left, err := cl.removeFolder(ctx, summary, folder.path)
+		if err != nil {
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+		if left > 0 {
+			cl.printFolderLeft(summary, folder.path, left)
+			c.action = actionPartial
+			return
+		}
+		if err != nil {
+			c.action = actionError
+			return
+		}
+		c.action = actionRemoved
+	}
+	summary.orphansRemoved++
+}
+
+// cleanDeletedFolder removes the noncurrent versions and delete markers
+// left of an upload folder whose objects were all deleted in a versioned
+// bucket, e.g. by the registry when the upload completed. They are judged
+// by the newest of them, usually the delete markers.
+func (cl *Cleaner) cleanDeletedFolder(ctx context.Context, summary *runSummary, folder *uploadFolder) {
+	hoursSince := int(time.Since(folder.newest).Hours())
+	age := fmt.Sprintf("%d hours since deleted%s", hoursSince, cl.thresholdNote(summary.bucket, folder.path))
+
+	c := folder.candidate(summary.bucket, "deleted-folder")
+	c.started, c.hours = folder.newest, hoursSince
+	defer func() { cl.record(summary, c) }()
+
+	if !cl.stale(folder.newest, summary.bucket, folder.path) {
+		cl.printf("  Skipping deleted folder %s (%s)\n", folder.path, age)
+		return
+	}
+
+	if cl.cfg.DryRun {
+		cl.printf("  Would remove %d versions and delete markers of deleted folder %s (%s)\n", folder.versions, folder.path, age)
+		if cl.cfg.DryRunDetail == "keys" {
+			size, err := cl.previewFolder(ctx, summary, folder.path)
+			if err != nil {
+				cl.printf(" ERROR: %s\n", err)
+				cl.addError(summary, err)
+				c.action = actionError
+				return
+			}
+			c.size = size
+		}
+		c.action = actionWouldRemove
+	} else {
+		cl.printf("  Removing %d versions and delete markers of deleted folder %s (%s)\n", folder.versions, folder.path, age)
+		left, err := cl.removeFolder(ctx, summary, folder.path)
+		if err != nil {
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+		if left > 0 {
+			cl.printFolderLeft(summary, folder.path, left)
+			c.action = actionPartial
+			return
+		}
+		if err != nil {
+			c.action = actionError
+			return
+		}
+		c.action = actionRemoved
+	}
+	summary.deletedRemoved++
+}
+
+// cleanEmptyFolder removes an upload folder holding nothing but directory
+// placeholders, with --prune-empty-uploads. There is nothing in it to lose,
+// so its age doesn't matter.
+func (cl *Cleaner) cleanEmptyFolder(ctx context.Context, summary *runSummary, folder *uploadFolder) {
+	c := folder.candidate(summary.bucket, "empty-folder")
+	defer func() { cl.record(summary, c) }()
+
+	if cl.cfg.DryRun {
+		cl.printf("  Would remove empty folder %s (only directory placeholders)\n", folder.path)
+		if cl.cfg.DryRunDetail == "keys" {
+			if _, err := cl.previewFolder(ctx, summary, folder.path); err != nil {
+				cl.printf(" ERROR: %s\n", err)
+				cl.addError(summary, err)
+				c.action = actionError
+				return
+			}
+		}
+		summary.placeholdersRemoved += folder.placeholders
+		c.action = actionWouldRemove
+	} else {
+		cl.printf("  Removing empty folder %s\n", folder.path)
+		left, err := cl.removeFolder(ctx, summary, folder.path)
+		if err != nil {
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+		}
+		if left > 0 {
+			cl.printFolderLeft(summary, folder.path, left)
+			c.action = actionPartial
+			return
+		}
+		if err != nil {
+			c.action = actionError
+			return
+		}
+		c.action = actionRemoved
+	}
+	summary.emptyRemoved++
+}
+
+// pruneUploadsDir removes the placeholder of the _uploads/ directory dir
+// when nothing is left below it, with --prune-empty-uploads. In a dry run
+// the folders below it are still there, so only directories that are
+// empty already are reported.
+func (cl *Cleaner) pruneUploadsDir(ctx context.Context, summary *runSummary, dir string) {
+	// Two keys tell whether anything but the placeholder is left, the
+	// placeholder sorts first.
+	resp, err := cl.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(summary.bucket),
+		Prefix:  aws.String(dir),
+		MaxKeys: aws.Int32(2),
+	})
+	if err != nil {
+		err = fmt.Errorf("listing %s: %w", dir, err)
+		cl.printf(" ERROR: %s\n", err)
+		cl.addError(summary, err)
+		return
+	}
+	if len(resp.Contents) != 1 || *resp.Contents[0].Key != dir {
+		return
+	}
+
+	objects := []objectVersion{{key: dir, size: aws.ToInt64(resp.Contents[0].Size), current: true}}
+	if summary.versioned {
+		if objects, err = cl.keyVersions(ctx, summary, dir); err != nil {
+			err = fmt.Errorf("listing versions of %s: %w", dir, err)
+			cl.printf(" ERROR: %s\n", err)
+			cl.addError(summary, err)
+			return
+		}
+	}
+
+	if cl.cfg.DryRun {
+		cl.printf("  Would remove empty placeholder %s\n", dir)
+		summary.placeholdersRemoved++
+		return
+	}
+
+	cl.printf("  Removing empty placeholder %s\n", dir)
+	if _, err := cl.deleteKeys(ctx, summary, objects); err != nil {
+		cl.printf(" ERROR: %s\n", err)
+		cl.addError(summary, err)
+	}
+}
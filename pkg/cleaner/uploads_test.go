@@ -0,0 +1,66 @@
+package cleaner
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxKeysRecorder is a fakeS3 recording the MaxKeys of the object listings
+// of prefix.
+type maxKeysRecorder struct {
+	*fakeS3
+	prefix  string
+	maxKeys []int32
+}
+
+func (m *maxKeysRecorder) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if aws.ToString(params.Prefix) == m.prefix {
+		m.maxKeys = append(m.maxKeys, aws.ToInt32(params.MaxKeys))
+	}
+	return m.fakeS3.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestPruneUploadsDir(t *testing.T) {
+	dir := testRepositories + "repo/_uploads/"
+	for _, tt := range []struct {
+		name      string
+		versioned bool
+		started   time.Time
+		left      []string
+	}{
+		{"stale folder", false, time.Now().Add(-48 * time.Hour), nil},
+		{"stale folder, versioned", true, time.Now().Add(-48 * time.Hour), nil},
+		{"fresh folder", false, time.Now(), []string{dir, dir + "u/data0", dir + "u/data1", dir + "u/data2", dir + "u/startedat"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeS3()
+			f.versioned = tt.versioned
+			f.put(dir, nil, time.Now().Add(-48*time.Hour))
+			f.put(dir, nil, time.Now().Add(-48*time.Hour))
+			f.putUpload(dir+"u/", tt.started, 1, 1, 1)
+
+			cl, out := newTestCleaner(t, f, Config{PruneEmptyUploads: true})
+			recorder := &maxKeysRecorder{fakeS3: f, prefix: dir}
+			cl.client = recorder
+			report := run(t, cl, out)
+
+			if got := f.keys(); !slices.Equal(got, tt.left) {
+				t.Errorf("keys left %v, want %v\n%s", got, tt.left, out)
+			}
+			if tt.versioned && len(f.objects) != 0 {
+				t.Errorf("versions left of %v", f.sortedKeys(""))
+			}
+			if report.ErrorCount != 0 {
+				t.Errorf("%d errors\n%s", report.ErrorCount, out)
+			}
+			if !slices.Equal(recorder.maxKeys, []int32{2}) {
+				t.Errorf("listed %s with MaxKeys %v, want a single listing of 2", dir, recorder.maxKeys)
+			}
+		})
+	}
+}
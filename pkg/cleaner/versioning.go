@@ -0,0 +1,199 @@
+package cleaner
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// bucketVersioned reports whether objects in bucket have to be deleted by
+// version. Suspended versioning still keeps the versions created while it
+// was enabled, so it counts as versioned too.
+func (cl *Cleaner) bucketVersioned(ctx context.Context, bucket string) bool {
+	if cl.cfg.Versioned {
+		return true
+	}
+
+	resp, err := cl.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+
+	if err != nil {
+		cl.printf(" WARNING: can't get versioning of bucket %s, assuming it isn't versioned (use --versioned otherwise): %s\n", bucket, err)
+		return false
+	}
+
+	switch resp.Status {
+	case types.BucketVersioningStatusEnabled, types.BucketVersioningStatusSuspended:
+		cl.printf("Bucket versioning: %s\n\n", resp.Status)
+		return true
+	}
+
+	return false
+}
+
+// folderEntry is an object listed by cleanUploadFolders. In versioned
+// buckets it is a version or a delete marker, and current is only set for
+// the latest version of an object that isn't deleted.
+type folderEntry struct {
+	types.Object
+	current bool
+}
+
+// folderLister pages through the objects below a prefix, or with
+// CleanDeletedFolders in versioned buckets through every version and
+// delete marker, so upload folders whose objects were all deleted, leaving
+// noncurrent versions and delete markers behind, are found too. A failed
+// page leaves the lister where it was.
+type folderLister struct {
+	objects  *s3.ListObjectsV2Paginator
+	versions *s3.ListObjectVersionsPaginator
+}
+
+func (cl *Cleaner) newFolderLister(summary *runSummary, prefix string) folderLister {
+	if summary.versioned && cl.cfg.CleanDeletedFolders {
+		return folderLister{versions: s3.NewListObjectVersionsPaginator(cl.client, &s3.ListObjectVersionsInput{
+			Bucket:  aws.String(summary.bucket),
+			Prefix:  aws.String(prefix),
+			MaxKeys: aws.Int32(100),
+		})}
+	}
+	return folderLister{objects: s3.NewListObjectsV2Paginator(cl.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(summary.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(100),
+	})}
+}
+
+func (l folderLister) HasMorePages() bool {
+	if l.versions != nil {
+		return l.versions.HasMorePages()
+	}
+	return l.objects.HasMorePages()
+}
+
+// NextPage returns the next page of entries, in key order.
+func (l folderLister) NextPage(ctx context.Context) ([]folderEntry, error) {
+	if l.versions == nil {
+		page, err := l.objects.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]folderEntry, 0, len(page.Contents))
+		for _, o := range page.Contents {
+			entries = append(entries, folderEntry{Object: o, current: true})
+		}
+		return entries, nil
+	}
+
+	page, err := l.versions.NextPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]folderEntry, 0, len(page.Versions)+len(page.DeleteMarkers))
+	for _, v := range page.Versions {
+		entries = append(entries, folderEntry{
+			Object:  types.Object{Key: v.Key, Size: v.Size, LastModified: v.LastModified},
+			current: aws.ToBool(v.IsLatest),
+		})
+	}
+	for _, m := range page.DeleteMarkers {
+		entries = append(entries, folderEntry{
+			Object: types.Object{Key: m.Key, Size: aws.Int64(0), LastModified: m.LastModified},
+		})
+	}
+	// Versions and delete markers come in separate lists, each in key
+	// order; the folders are collected from one list in key order.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return *entries[i].Key < *entries[j].Key
+	})
+	return entries, nil
+}
+
+// keyVersions lists the versions and delete markers of key alone, not of
+// the keys it is a prefix of. They are listed first, so the listing stops
+// at the first other key.
+func (cl *Cleaner) keyVersions(ctx context.Context, summary *runSummary, key string) ([]objectVersion, error) {
+	var objects []objectVersion
+	paginator := s3.NewListObjectVersionsPaginator(cl.client, &s3.ListObjectVersionsInput{
+		Bucket:  aws.String(summary.bucket),
+		Prefix:  aws.String(key),
+		MaxKeys: aws.Int32(100),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		others := false
+		for _, v := range page.Versions {
+			if *v.Key != key {
+				others = true
+				continue
+			}
+			objects = append(objects, objectVersion{
+				key:       key,
+				versionID: aws.ToString(v.VersionId),
+				size:      aws.ToInt64(v.Size),
+				current:   aws.ToBool(v.IsLatest),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			if *m.Key != key {
+				others = true
+				continue
+			}
+			objects = append(objects, objectVersion{key: key, versionID: aws.ToString(m.VersionId)})
+		}
+		if others {
+			break
+		}
+	}
+
+	return objects, nil
+}
+
+// folderVersions lists every version and delete marker below prefix.
+func (cl *Cleaner) folderVersions(ctx context.Context, summary *runSummary, prefix string) ([]objectVersion, error) {
+	var objects []objectVersion
+	paginator := s3.NewListObjectVersionsPaginator(cl.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(summary.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Versions {
+			if !cl.inFolder(summary, prefix, *v.Key) {
+				continue
+			}
+			objects = append(objects, objectVersion{
+				key:       *v.Key,
+				versionID: aws.ToString(v.VersionId),
+				size:      aws.ToInt64(v.Size),
+				current:   aws.ToBool(v.IsLatest),
+			})
+		}
+
+		for _, m := range page.DeleteMarkers {
+			if !cl.inFolder(summary, prefix, *m.Key) {
+				continue
+			}
+			objects = append(objects, objectVersion{
+				key:       *m.Key,
+				versionID: aws.ToString(m.VersionId),
+			})
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a size flag accepting decimal (KB, MB, GB, TB) and binary
+// (KiB, MiB, GiB, TiB) units, e.g. 100MB or 1.5GiB. Plain numbers are bytes.
+type byteSize int64
+
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"b", 1},
+}
+
+// UnmarshalFlag implements flags.Unmarshaler.
+func (s *byteSize) UnmarshalFlag(value string) error {
+	number, factor := strings.ToLower(strings.TrimSpace(value)), 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number, factor = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q, expected e.g. 100MB or 1GiB", value)
+	}
+	*s = byteSize(n * factor)
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stonezdj/s3-upload-cleaner/pkg/cleaner"
+)
+
+// Version of the stats file format, increased on incompatible changes.
+const statsVersion = 1
+
+// statsFile is the content of --stats-file.
+type statsFile struct {
+	Version int        `json:"version"`
+	Runs    []runStats `json:"runs"`
+}
+
+// runStats is the record kept in the stats file for every run.
+type runStats struct {
+	Time             time.Time  `json:"time"`
+	DurationSeconds  float64    `json:"duration_seconds"`
+	Bucket           string     `json:"bucket"`
+	DryRun           bool       `json:"dry_run"`
+	ThresholdSeconds float64    `json:"threshold_seconds,omitempty"`
+	OlderThan        *time.Time `json:"older_than,omitempty"`
+	MPUsAborted      int        `json:"mpus_aborted"`
+	FoldersRemoved   int        `json:"folders_removed"`
+	ObjectsDeleted   int64      `json:"objects_deleted"`
+	BytesReclaimed   int64      `json:"bytes_reclaimed"`
+	ErrorCount       int        `json:"error_count"`
+
+	APICalls map[string]cleaner.APICallStats `json:"api_calls,omitempty"`
+}
+
+// readStats reads the stats file at path, a missing file has no runs.
+func readStats(path string) (statsFile, error) {
+	stats := statsFile{Version: statsVersion}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if err := json.Unmarshal(content, &stats); err != nil {
+		return stats, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if stats.Version != statsVersion {
+		return stats, fmt.Errorf("%s has version %d, expected %d", path, stats.Version, statsVersion)
+	}
+	return stats, nil
+}
+
+// recordStats adds the run to the stats file at path, keeping the last keep
+// runs (all for 0). The file is replaced atomically, so a crash never
+// leaves it half written. Failures are only printed.
+func recordStats(path string, keep int, threshold time.Duration, olderThan time.Time, report cleaner.Report) {
+	var older *time.Time
+	if !olderThan.IsZero() {
+		older = &olderThan
+	}
+
+	if err := writeStats(path, keep, runStats{
+		Time:             report.Started,
+		DurationSeconds:  report.DurationSeconds,
+		Bucket:           report.Bucket,
+		DryRun:           report.DryRun,
+		ThresholdSeconds: threshold.Seconds(),
+		OlderThan:        older,
+		MPUsAborted:      report.MPUsAborted,
+		FoldersRemoved:   report.FoldersRemoved,
+		ObjectsDeleted:   report.ObjectsDeleted,
+		BytesReclaimed:   report.BytesReclaimed,
+		ErrorCount:       report.ErrorCount,
+		APICalls:         report.APICalls,
+	}); err != nil {
+		fmt.Printf(" ERROR: stats file: %s\n", err)
+	}
+}
+
+func writeStats(path string, keep int, run runStats) error {
+	stats, err := readStats(path)
+	if err != nil {
+		return err
+	}
+
+	stats.Runs = append(stats.Runs, run)
+	if keep > 0 && len(stats.Runs) > keep {
+		stats.Runs = stats.Runs[len(stats.Runs)-keep:]
+	}
+
+	content, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// printStats prints the runs of the stats file at path as a table, oldest
+// first.
+func printStats(path string) error {
+	stats, err := readStats(path)
+	if err != nil {
+		return err
+	}
+	if len(stats.Runs) == 0 {
+		fmt.Printf("No runs recorded in %s\n", path)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Time\tBucket\tDry run\tDuration\tMPUs\tFolders\tObjects\tReclaimed\tErrors\tAPI calls")
+	for _, r := range stats.Runs {
+		var calls int64
+		for _, op := range r.APICalls {
+			calls += op.Calls
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%d\t%d\t%d\t%s\t%d\t%d\n",
+			r.Time.Local().Format("2006-01-02 15:04"), r.Bucket, r.DryRun,
+			(time.Duration(r.DurationSeconds) * time.Second).Round(time.Second),
+			r.MPUsAborted, r.FoldersRemoved, r.ObjectsDeleted, cleaner.FormatBytes(r.BytesReclaimed), r.ErrorCount, calls)
+	}
+	return w.Flush()
+}